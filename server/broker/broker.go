@@ -0,0 +1,50 @@
+// Package broker relays messages between the servers of a deployment, so
+// events reach the clients connected to any of them.
+//
+// Topics are published to by any server and delivered to the Handler of every
+// server subscribed to them, the publishing one included. Servers only
+// subscribe to the topics their connected clients are interested in.
+package broker
+
+import (
+	"context"
+	"fmt"
+	"main/lib"
+	"main/state"
+)
+
+// Handler receives the messages published to the subscribed topics. Messages
+// of a topic are handled in the order they were published.
+type Handler func(topic string, payload []byte)
+
+type Broker interface {
+	Publish(ctx context.Context, topic string, payload []byte) error
+	Subscribe(ctx context.Context, topics ...string) error
+	Unsubscribe(ctx context.Context, topics ...string) error
+	// Ping reports whether the broker can currently publish and receive.
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// Kinds of broker accepted by the BROKER env.
+const (
+	KindMemory   = "memory"
+	KindRedis    = "redis"
+	KindPostgres = "postgres"
+)
+
+// New returns the broker selected by the BROKER env, memory when unset, which
+// only suits a single server. The redis broker connects to REDIS_ADDR, the
+// postgres one to the chat database.
+func New(handler Handler) (Broker, error) {
+	switch kind := string(lib.GetDotEnv("BROKER")); kind {
+	case "", KindMemory:
+		return NewMemory(handler), nil
+	case KindRedis:
+		return NewRedis(string(lib.GetDotEnv("REDIS_ADDR")), handler)
+	case KindPostgres:
+		return NewPostgres(state.DSN(), handler)
+	default:
+		return nil, fmt.Errorf("unknown broker %q", kind)
+	}
+}
@@ -0,0 +1,99 @@
+package broker
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryBus connects Memory brokers within a process, standing for the
+// network between servers in tests.
+type MemoryBus struct {
+	mu    sync.RWMutex
+	nodes []*Memory
+}
+
+// Memory is a broker delivering synchronously, before Publish returns.
+type Memory struct {
+	bus     *MemoryBus
+	handler Handler
+	mu      sync.RWMutex
+	topics  map[string]struct{}
+}
+
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{}
+}
+
+// Connect adds a broker to the bus, receiving what any broker of the bus
+// publishes.
+func (b *MemoryBus) Connect(handler Handler) *Memory {
+	node := &Memory{bus: b, handler: handler, topics: make(map[string]struct{})}
+	b.mu.Lock()
+	b.nodes = append(b.nodes, node)
+	b.mu.Unlock()
+	return node
+}
+
+// NewMemory returns a broker on a bus of its own, for a single server.
+func NewMemory(handler Handler) *Memory {
+	return NewMemoryBus().Connect(handler)
+}
+
+func (m *Memory) Publish(_ context.Context, topic string, payload []byte) error {
+	m.bus.mu.RLock()
+	nodes := append([]*Memory(nil), m.bus.nodes...)
+	m.bus.mu.RUnlock()
+
+	for _, node := range nodes {
+		if node.subscribed(topic) {
+			node.handler(topic, payload)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Subscribe(_ context.Context, topics ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, topic := range topics {
+		m.topics[topic] = struct{}{}
+	}
+	return nil
+}
+
+func (m *Memory) Unsubscribe(_ context.Context, topics ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, topic := range topics {
+		delete(m.topics, topic)
+	}
+	return nil
+}
+
+func (m *Memory) Ping(context.Context) error {
+	return nil
+}
+
+// Close disconnects the broker from its bus.
+func (m *Memory) Close() error {
+	m.bus.mu.Lock()
+	defer m.bus.mu.Unlock()
+
+	for i, node := range m.bus.nodes {
+		if node == m {
+			m.bus.nodes = append(m.bus.nodes[:i], m.bus.nodes[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (m *Memory) subscribed(topic string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, ok := m.topics[topic]
+	return ok
+}
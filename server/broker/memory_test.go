@@ -0,0 +1,42 @@
+package broker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Publish reaches the subscribed brokers of the bus", func(t *testing.T) {
+		bus := NewMemoryBus()
+		var first, second []string
+		a := bus.Connect(func(topic string, payload []byte) { first = append(first, topic+"="+string(payload)) })
+		b := bus.Connect(func(topic string, payload []byte) { second = append(second, topic+"="+string(payload)) })
+		assert.NoError(t, a.Subscribe(ctx, "room:general"))
+		assert.NoError(t, b.Subscribe(ctx, "room:general", "user:bob"))
+
+		assert.NoError(t, a.Publish(ctx, "room:general", []byte("hello")))
+		assert.NoError(t, a.Publish(ctx, "user:bob", []byte("hi")))
+
+		assert.Equal(t, []string{"room:general=hello"}, first)
+		assert.Equal(t, []string{"room:general=hello", "user:bob=hi"}, second)
+	})
+
+	t.Run("Unsubscribed and closed brokers receive nothing", func(t *testing.T) {
+		bus := NewMemoryBus()
+		received := 0
+		a := bus.Connect(func(string, []byte) { received++ })
+		b := bus.Connect(func(string, []byte) { received++ })
+		assert.NoError(t, a.Subscribe(ctx, "room:general"))
+		assert.NoError(t, b.Subscribe(ctx, "room:general"))
+
+		assert.NoError(t, a.Unsubscribe(ctx, "room:general"))
+		assert.NoError(t, b.Close())
+		assert.NoError(t, a.Publish(ctx, "room:general", []byte("hello")))
+
+		assert.Zero(t, received)
+	})
+}
@@ -0,0 +1,353 @@
+package broker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"main/lib"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+)
+
+const (
+	// postgresPrefix namespaces the channels of the chat in a shared database
+	postgresPrefix = "p-chat:"
+	// maxChannelLength is the longest identifier Postgres keeps, longer topics
+	// get a hashed channel name
+	maxChannelLength = 63
+	// maxNotifyPayload stays under the 8000 bytes NOTIFY accepts
+	maxNotifyPayload = 7900
+	// inline and stored prefix the notifications carrying the payload itself
+	// or the id of its broker_messages row
+	inlinePayload = '='
+	storedPayload = '#'
+
+	storedPayloadTTL    = time.Minute
+	postgresRetryDelay  = time.Second
+	postgresDialTimeout = 5 * time.Second
+)
+
+// Postgres is a broker on Postgres LISTEN/NOTIFY, for clusters that don't
+// run Redis. Payloads too big for a notification are stored in the
+// broker_messages table and fetched by id. A dedicated connection listens to
+// the subscribed channels and listens again after a reconnect, messages
+// published meanwhile are lost.
+type Postgres struct {
+	pool    *pgxpool.Pool
+	dsn     string
+	handler Handler
+
+	mu sync.Mutex
+	// channels maps the channels listened to to their topic
+	channels map[string]string
+	// pending holds the LISTEN and UNLISTEN statements the listener has yet
+	// to run, interrupt wakes it up to do so
+	pending   []string
+	interrupt context.CancelFunc
+	// listening is false while the listener reconnects
+	listening atomic.Bool
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewPostgres connects to the database at dsn and starts passing the messages
+// of subscribed topics to handler.
+func NewPostgres(dsn string, handler Handler) (*Postgres, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), postgresDialTimeout)
+	defer cancel()
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+
+	listenCtx, stop := context.WithCancel(context.Background())
+	p := &Postgres{
+		pool:     pool,
+		dsn:      dsn,
+		handler:  handler,
+		channels: make(map[string]string),
+		cancel:   stop,
+		done:     make(chan struct{}),
+	}
+	p.listening.Store(true)
+	go p.listen(listenCtx, conn)
+	go p.sweep(listenCtx)
+	return p, nil
+}
+
+func (p *Postgres) Publish(ctx context.Context, topic string, payload []byte) error {
+	notification, ok := inlineNotification(payload)
+	if !ok {
+		var id int64
+		err := p.pool.QueryRow(ctx, "INSERT INTO broker_messages (payload) VALUES ($1) RETURNING id", payload).Scan(&id)
+		if err != nil {
+			return err
+		}
+		notification = storedNotification(id)
+	}
+
+	_, err := p.pool.Exec(ctx, "SELECT pg_notify($1, $2)", channelName(topic), notification)
+	return err
+}
+
+// Subscribe queues LISTEN statements for the listener and returns without
+// waiting for them to run.
+func (p *Postgres) Subscribe(_ context.Context, topics ...string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, topic := range topics {
+		channel := channelName(topic)
+		p.channels[channel] = topic
+		p.pending = append(p.pending, "LISTEN "+pgx.Identifier{channel}.Sanitize())
+	}
+	p.wake()
+	return nil
+}
+
+// Unsubscribe queues UNLISTEN statements for the listener and returns without
+// waiting for them to run.
+func (p *Postgres) Unsubscribe(_ context.Context, topics ...string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, topic := range topics {
+		channel := channelName(topic)
+		delete(p.channels, channel)
+		p.pending = append(p.pending, "UNLISTEN "+pgx.Identifier{channel}.Sanitize())
+	}
+	p.wake()
+	return nil
+}
+
+var errNotListening = errors.New("postgres broker is reconnecting")
+
+func (p *Postgres) Ping(ctx context.Context) error {
+	if !p.listening.Load() {
+		return errNotListening
+	}
+	return p.pool.Ping(ctx)
+}
+
+// Close stops listening and waits for the handler to return.
+func (p *Postgres) Close() error {
+	p.cancel()
+	<-p.done
+	p.pool.Close()
+	return nil
+}
+
+// wake expects p.mu to be held.
+func (p *Postgres) wake() {
+	if p.interrupt != nil {
+		p.interrupt()
+		p.interrupt = nil
+	}
+}
+
+// listen owns conn, running the pending statements and waiting for
+// notifications in turn, and reconnects when conn fails.
+func (p *Postgres) listen(ctx context.Context, conn *pgx.Conn) {
+	defer close(p.done)
+	defer func() {
+		if conn != nil {
+			conn.Close(context.Background())
+		}
+	}()
+
+	for ctx.Err() == nil {
+		if conn == nil {
+			conn = p.reconnect(ctx)
+			continue
+		}
+
+		p.mu.Lock()
+		statements := p.pending
+		p.pending = nil
+		p.mu.Unlock()
+		if err := execAll(ctx, conn, statements); err != nil {
+			p.dropConnection(ctx, conn, err)
+			conn = nil
+			continue
+		}
+
+		p.mu.Lock()
+		if len(p.pending) > 0 {
+			p.mu.Unlock()
+			continue
+		}
+		waitCtx, interrupt := context.WithCancel(ctx)
+		p.interrupt = interrupt
+		p.mu.Unlock()
+
+		notification, err := conn.WaitForNotification(waitCtx)
+		interrupt()
+		if err != nil {
+			// Interrupted to run new statements
+			if waitCtx.Err() != nil && ctx.Err() == nil && !conn.IsClosed() {
+				continue
+			}
+			p.dropConnection(ctx, conn, err)
+			conn = nil
+			continue
+		}
+		p.receive(ctx, notification.Channel, notification.Payload)
+	}
+}
+
+func (p *Postgres) receive(ctx context.Context, channel string, notification string) {
+	p.mu.Lock()
+	topic, ok := p.channels[channel]
+	p.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	payload, id, err := decodeNotification(notification)
+	if err != nil {
+		lib.GetLogger().Warn("invalid notification", zap.String("channel", channel))
+		return
+	}
+	if payload == nil {
+		err = p.pool.QueryRow(ctx, "SELECT payload FROM broker_messages WHERE id = $1", id).Scan(&payload)
+		if err != nil {
+			lib.GetLogger().Error("failed to fetch stored payload", zap.String("topic", topic), zap.Error(err))
+			return
+		}
+	}
+	p.handler(topic, payload)
+}
+
+// reconnect dials a new listening connection, queueing LISTEN statements for
+// every subscribed channel. It returns nil when ctx is done or dialing failed.
+func (p *Postgres) reconnect(ctx context.Context) *pgx.Conn {
+	dialCtx, cancel := context.WithTimeout(ctx, postgresDialTimeout)
+	defer cancel()
+	conn, err := pgx.Connect(dialCtx, p.dsn)
+	if err != nil {
+		p.dropConnection(ctx, nil, err)
+		return nil
+	}
+
+	p.listening.Store(true)
+	p.relisten()
+	return conn
+}
+
+// relisten replaces the pending statements with a LISTEN for every
+// subscribed channel, a new connection listening to nothing yet.
+func (p *Postgres) relisten() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.pending = p.pending[:0]
+	for channel := range p.channels {
+		p.pending = append(p.pending, "LISTEN "+pgx.Identifier{channel}.Sanitize())
+	}
+}
+
+// dropConnection closes conn, if any, and waits before the next attempt.
+func (p *Postgres) dropConnection(ctx context.Context, conn *pgx.Conn, err error) {
+	p.listening.Store(false)
+	if ctx.Err() != nil {
+		return
+	}
+	lib.GetLogger().Error("postgres broker connection failed", zap.Error(err))
+	if conn != nil {
+		conn.Close(context.Background())
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(postgresRetryDelay):
+	}
+}
+
+// sweep deletes the stored payloads every server had time to fetch.
+func (p *Postgres) sweep(ctx context.Context) {
+	ticker := time.NewTicker(storedPayloadTTL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		_, err := p.pool.Exec(ctx, "DELETE FROM broker_messages WHERE created_at < CURRENT_TIMESTAMP - make_interval(secs => $1)", storedPayloadTTL.Seconds())
+		if err != nil && !errors.Is(err, context.Canceled) {
+			lib.GetLogger().Error("failed to sweep stored payloads", zap.Error(err))
+		}
+	}
+}
+
+// inlineNotification returns the notification carrying the payload itself,
+// or false when the payload has to be stored: notifications are text of at
+// most maxNotifyPayload bytes.
+func inlineNotification(payload []byte) (string, bool) {
+	notification := string(inlinePayload) + string(payload)
+	if len(notification) > maxNotifyPayload || !utf8.Valid(payload) || strings.ContainsRune(notification, 0) {
+		return "", false
+	}
+	return notification, true
+}
+
+// storedNotification returns the notification of a payload stored with id.
+func storedNotification(id int64) string {
+	return string(storedPayload) + strconv.FormatInt(id, 10)
+}
+
+var errInvalidNotification = errors.New("invalid notification")
+
+// decodeNotification returns the payload of an inline notification, or nil
+// and the id of the stored payload.
+func decodeNotification(notification string) ([]byte, int64, error) {
+	if notification == "" {
+		return nil, 0, errInvalidNotification
+	}
+	switch notification[0] {
+	case inlinePayload:
+		return []byte(notification[1:]), 0, nil
+	case storedPayload:
+		id, err := strconv.ParseInt(notification[1:], 10, 64)
+		if err != nil {
+			return nil, 0, errInvalidNotification
+		}
+		return nil, id, nil
+	}
+	return nil, 0, errInvalidNotification
+}
+
+func execAll(ctx context.Context, conn *pgx.Conn, statements []string) error {
+	for _, statement := range statements {
+		if _, err := conn.Exec(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// channelName returns the channel of the topic, hashed when the topic is too
+// long for a Postgres identifier.
+func channelName(topic string) string {
+	channel := postgresPrefix + topic
+	if len(channel) <= maxChannelLength {
+		return channel
+	}
+	sum := sha256.Sum256([]byte(topic))
+	return postgresPrefix + hex.EncodeToString(sum[:24])
+}
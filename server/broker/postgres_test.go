@@ -0,0 +1,85 @@
+package broker
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelName(t *testing.T) {
+	t.Run("Short topics keep a readable channel", func(t *testing.T) {
+		assert.Equal(t, "p-chat:room:general", channelName("room:general"))
+	})
+
+	t.Run("Long topics are hashed to fit an identifier", func(t *testing.T) {
+		topic := "room:" + strings.Repeat("a", 100)
+		channel := channelName(topic)
+
+		assert.LessOrEqual(t, len(channel), maxChannelLength)
+		assert.Equal(t, channel, channelName(topic))
+		assert.NotEqual(t, channel, channelName(topic+"b"))
+	})
+}
+
+func TestNotification(t *testing.T) {
+	t.Run("Small text payloads travel in the notification", func(t *testing.T) {
+		notification, ok := inlineNotification([]byte(`{"kind":"event"}`))
+		assert.True(t, ok)
+
+		payload, _, err := decodeNotification(notification)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte(`{"kind":"event"}`), payload)
+	})
+
+	t.Run("Empty payloads travel in the notification", func(t *testing.T) {
+		notification, ok := inlineNotification(nil)
+		assert.True(t, ok)
+
+		payload, _, err := decodeNotification(notification)
+		assert.NoError(t, err)
+		assert.NotNil(t, payload)
+		assert.Empty(t, payload)
+	})
+
+	t.Run("Payloads too big or not text are stored", func(t *testing.T) {
+		_, ok := inlineNotification([]byte(strings.Repeat("a", maxNotifyPayload)))
+		assert.False(t, ok)
+		_, ok = inlineNotification([]byte(strings.Repeat("a", maxNotifyPayload-1)))
+		assert.True(t, ok)
+		_, ok = inlineNotification([]byte{0xff, 0xfe})
+		assert.False(t, ok)
+		_, ok = inlineNotification([]byte("a\x00b"))
+		assert.False(t, ok)
+	})
+
+	t.Run("Stored payloads are notified by id", func(t *testing.T) {
+		payload, id, err := decodeNotification(storedNotification(42))
+		assert.NoError(t, err)
+		assert.Nil(t, payload)
+		assert.Equal(t, int64(42), id)
+	})
+
+	t.Run("Invalid notifications are rejected", func(t *testing.T) {
+		for _, notification := range []string{"", "x", "#", "#abc"} {
+			_, _, err := decodeNotification(notification)
+			assert.ErrorIs(t, err, errInvalidNotification, notification)
+		}
+	})
+}
+
+func TestRelisten(t *testing.T) {
+	t.Run("New connection listens to every subscribed channel", func(t *testing.T) {
+		p := &Postgres{channels: make(map[string]string)}
+		assert.NoError(t, p.Subscribe(context.Background(), "room:general", "user:alice", "room:random"))
+		assert.NoError(t, p.Unsubscribe(context.Background(), "room:random"))
+
+		p.relisten()
+
+		assert.ElementsMatch(t, []string{
+			`LISTEN "p-chat:room:general"`,
+			`LISTEN "p-chat:user:alice"`,
+		}, p.pending)
+	})
+}
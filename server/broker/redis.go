@@ -0,0 +1,94 @@
+package broker
+
+import (
+	"context"
+	"main/lib"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	// redisPrefix namespaces the channels of the chat in a shared Redis
+	redisPrefix = "p-chat:"
+	// redisChannelSize buffers the messages received before the handler runs
+	redisChannelSize = 1024
+	redisDialTimeout = 5 * time.Second
+)
+
+// Redis is a broker on Redis pub/sub. A single connection carries the
+// subscriptions of the server and is resubscribed after a reconnect, messages
+// published meanwhile are lost.
+type Redis struct {
+	client *redis.Client
+	pubsub *redis.PubSub
+	done   chan struct{}
+}
+
+// NewRedis connects to the Redis server at addr and starts passing the
+// messages of subscribed topics to handler.
+func NewRedis(addr string, handler Handler) (*Redis, error) {
+	client := redis.NewClient(&redis.Options{Addr: addr, DialTimeout: redisDialTimeout})
+	ctx, cancel := context.WithTimeout(context.Background(), redisDialTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	r := &Redis{
+		client: client,
+		pubsub: client.Subscribe(context.Background()),
+		done:   make(chan struct{}),
+	}
+	go r.receive(handler)
+	return r, nil
+}
+
+func (r *Redis) Publish(ctx context.Context, topic string, payload []byte) error {
+	return r.client.Publish(ctx, redisPrefix+topic, payload).Err()
+}
+
+func (r *Redis) Subscribe(ctx context.Context, topics ...string) error {
+	return r.pubsub.Subscribe(ctx, channels(topics)...)
+}
+
+func (r *Redis) Unsubscribe(ctx context.Context, topics ...string) error {
+	return r.pubsub.Unsubscribe(ctx, channels(topics)...)
+}
+
+func (r *Redis) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
+}
+
+// Close stops receiving and waits for the handler to return.
+func (r *Redis) Close() error {
+	err := r.pubsub.Close()
+	<-r.done
+	if closeErr := r.client.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (r *Redis) receive(handler Handler) {
+	defer close(r.done)
+	for message := range r.pubsub.Channel(redis.WithChannelSize(redisChannelSize)) {
+		topic, ok := strings.CutPrefix(message.Channel, redisPrefix)
+		if !ok {
+			lib.GetLogger().Warn("message on unknown channel", zap.String("channel", message.Channel))
+			continue
+		}
+		handler(topic, []byte(message.Payload))
+	}
+}
+
+func channels(topics []string) []string {
+	prefixed := make([]string, len(topics))
+	for i, topic := range topics {
+		prefixed[i] = redisPrefix + topic
+	}
+	return prefixed
+}
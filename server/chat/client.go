@@ -0,0 +1,291 @@
+package chat
+
+import (
+	"main/chat/protocol"
+	"main/lib"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// CloseSlowConsumer closes a socket whose client doesn't keep up with its
+// events.
+const CloseSlowConsumer = 4408
+
+const (
+	// maxHeldEvents is how many live events may pile up while a client is
+	// held before it is considered too slow and disconnected.
+	maxHeldEvents = 1024
+	// replayWriteTimeout is how long a replayed event may wait for room in
+	// the outbox.
+	replayWriteTimeout = 10 * time.Second
+)
+
+// Client is a connection bound to the user that opened it, an upgraded
+// websocket or, without conn, an SSE or long-poll connection. A user has one
+// Client per connected device.
+type Client struct {
+	ID     uuid.UUID
+	UserID string
+
+	conn      *websocket.Conn
+	codec     *protocol.Codec
+	config    socketConfig
+	outbox    *outbox
+	done      chan struct{}
+	closeOnce sync.Once
+	// closeCode and closeReason are written before done is closed
+	closeCode   int
+	closeReason string
+	// rooms the client is subscribed to, guarded by Hub.mu
+	rooms map[string]struct{}
+	// poll is set on long-poll clients
+	poll *pollState
+
+	// Live events are held back while missed ones are replayed, see hold.
+	holdMu    sync.Mutex
+	flushMu   sync.Mutex
+	holding   bool
+	replaying bool
+	held      []protocol.Event
+
+	// Commands run one at a time in the order they were sent, see queueCommand.
+	commandsMu     sync.Mutex
+	commandRunning bool
+	commands       []lib.Task[map[string]any]
+}
+
+func newClient(userID string, conn *websocket.Conn, config socketConfig) *Client {
+	return &Client{
+		ID:     uuid.New(),
+		UserID: userID,
+		conn:   conn,
+		codec:  protocol.JSON,
+		config: config,
+		outbox: newOutbox(config.outboxSize, config.outboxPolicy),
+		done:   make(chan struct{}),
+		rooms:  make(map[string]struct{}),
+	}
+}
+
+// Send queues an event for the client without blocking. When the outbox is
+// full its policy applies, and a client it can't make room for is closed, so
+// a stalled reader can't hold back a room.
+func (c *Client) Send(event protocol.Event) bool {
+	c.holdMu.Lock()
+	if c.holding {
+		if len(c.held) >= maxHeldEvents {
+			c.holdMu.Unlock()
+			c.evictSlowConsumer(len(c.held) + 1)
+			return false
+		}
+		c.held = append(c.held, event)
+		c.holdMu.Unlock()
+		return true
+	}
+	c.holdMu.Unlock()
+
+	return c.enqueue(event)
+}
+
+func (c *Client) enqueue(event protocol.Event) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	if !c.outbox.push(event) {
+		c.evictSlowConsumer(0)
+		return false
+	}
+	return true
+}
+
+// evictSlowConsumer closes the client, counting the lost events unless the
+// outbox already did.
+func (c *Client) evictSlowConsumer(lost int) {
+	if lost > 0 {
+		lib.RecordDroppedEvents(droppedDisconnected, lost)
+	}
+	lib.RecordWebSocketEviction(evictedSlowConsumer)
+	c.closeWith(CloseSlowConsumer, "slow consumer")
+}
+
+// hold buffers live events until a replay ends, or until timeout when no
+// replay has started by then.
+func (c *Client) hold(timeout time.Duration) {
+	c.holdMu.Lock()
+	c.holding = true
+	c.holdMu.Unlock()
+
+	time.AfterFunc(timeout, func() {
+		c.flushHeld(nil)
+	})
+}
+
+// beginReplay holds live events while missed ones are sent with replay.
+func (c *Client) beginReplay() {
+	c.holdMu.Lock()
+	c.holding = true
+	c.replaying = true
+	c.holdMu.Unlock()
+}
+
+// replay sends a missed event ahead of the held ones. Unlike Send it waits
+// for room in the outbox, as a replay easily outgrows it.
+func (c *Client) replay(event protocol.Event) bool {
+	if c.outbox.pushWait(event, c.done, replayWriteTimeout) {
+		return true
+	}
+	select {
+	case <-c.done:
+	default:
+		c.evictSlowConsumer(1)
+	}
+	return false
+}
+
+// endReplay switches back to live delivery, sending the held events first.
+// Events for which replayed returns true were already part of the replay.
+func (c *Client) endReplay(replayed func(protocol.Event) bool) {
+	c.holdMu.Lock()
+	c.replaying = false
+	c.holdMu.Unlock()
+
+	c.flushHeld(replayed)
+}
+
+// flushHeld sends the held events in order, unless a replay is running. Events
+// keep being held until the buffer is drained, so live ones can't overtake them.
+func (c *Client) flushHeld(skip func(protocol.Event) bool) {
+	c.flushMu.Lock()
+	defer c.flushMu.Unlock()
+
+	for {
+		c.holdMu.Lock()
+		if c.replaying {
+			c.holdMu.Unlock()
+			return
+		}
+		events := c.held
+		c.held = nil
+		if len(events) == 0 {
+			c.holding = false
+			c.holdMu.Unlock()
+			return
+		}
+		c.holdMu.Unlock()
+
+		for _, event := range events {
+			if skip != nil && skip(event) {
+				continue
+			}
+			if !c.replay(event) {
+				return
+			}
+		}
+	}
+}
+
+// Close stops the write goroutine, which closes the connection and in turn
+// ends the read loop.
+func (c *Client) Close() {
+	c.closeWith(websocket.CloseNormalClosure, "")
+}
+
+// closeWith closes the client, the socket being closed with code.
+func (c *Client) closeWith(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeReason = reason
+		close(c.done)
+	})
+}
+
+func (c *Client) messageType() int {
+	if c.codec.Binary {
+		return websocket.BinaryMessage
+	}
+	return websocket.TextMessage
+}
+
+// writePump writes queued events and pings the client every
+// config.pingInterval. A write that fails or exceeds config.writeWait closes
+// the client.
+func (c *Client) writePump() {
+	ticker := time.NewTicker(c.config.pingInterval)
+	defer func() {
+		ticker.Stop()
+		_ = c.conn.Close()
+	}()
+
+	for {
+		select {
+		case <-c.outbox.ready:
+			for {
+				event, ok := c.outbox.pop()
+				if !ok {
+					break
+				}
+				data, err := c.codec.Encode(event)
+				if err != nil {
+					lib.GetLogger().Error("failed to encode event", zap.String("type", event.Type), zap.Error(err))
+					continue
+				}
+				_ = c.conn.SetWriteDeadline(time.Now().Add(c.config.writeWait))
+				if err := c.conn.WriteMessage(c.messageType(), data); err != nil {
+					lib.RecordWebSocketEviction(evictedWriteFailed)
+					c.Close()
+					return
+				}
+				lib.RecordMessageOut(event.Type)
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.config.writeWait)); err != nil {
+				lib.RecordWebSocketEviction(evictedWriteFailed)
+				c.Close()
+				return
+			}
+		case <-c.done:
+			message := websocket.FormatCloseMessage(c.closeCode, c.closeReason)
+			_ = c.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(c.config.writeWait))
+			return
+		}
+	}
+}
+
+// queueCommand runs the command task on the worker pool once the commands the
+// client sent before it are done, so a connection's sends get their seq in
+// the order they were sent.
+func (c *Client) queueCommand(task lib.Task[map[string]any]) {
+	c.commandsMu.Lock()
+	if c.commandRunning {
+		c.commands = append(c.commands, task)
+		c.commandsMu.Unlock()
+		return
+	}
+	c.commandRunning = true
+	c.commandsMu.Unlock()
+
+	lib.GetConfig().WP.EnqueueTask(task)
+}
+
+// nextCommand is called when a command of the client is done, and queues the
+// following one on the worker pool.
+func (c *Client) nextCommand() {
+	c.commandsMu.Lock()
+	if len(c.commands) == 0 {
+		c.commandRunning = false
+		c.commandsMu.Unlock()
+		return
+	}
+	next := c.commands[0]
+	c.commands = c.commands[1:]
+	c.commandsMu.Unlock()
+
+	lib.GetConfig().WP.EnqueueTask(next)
+}
@@ -0,0 +1,63 @@
+package chat
+
+import (
+	"main/chat/protocol"
+	"main/lib"
+
+	"go.uber.org/zap"
+)
+
+// connect registers the client with the hub, subscribes it to the rooms of
+// its user and reports the user online, whatever transport the client uses.
+// A resuming client holds live events back until its resume command. The
+// returned function undoes it all once the client is gone.
+func connect(client *Client, resume bool) func() {
+	if resume {
+		client.hold(resumeHoldTimeout)
+	}
+	hub.Register(client)
+	if roomIDs, err := userRoomIDs(client.UserID); err == nil {
+		for _, roomID := range roomIDs {
+			hub.Subscribe(roomID, client)
+		}
+	} else {
+		lib.GetLogger().Warn("failed to subscribe client to its rooms", zap.Error(err))
+	}
+	presence.Connected(client.UserID)
+	ids := lib.GetConfig().WP.ScaleUp(1)
+
+	return func() {
+		lib.GetConfig().WP.ScaleDown(ids[0])
+		presence.Disconnected(client.UserID)
+		hub.Unregister(client)
+		client.Close()
+	}
+}
+
+// invalidFrame labels the frames failing validation in lib.RecordMessageIn.
+const invalidFrame = "invalid"
+
+// handleFrame decodes a client frame and queues its command on the worker
+// pool behind the client's previous ones. Frames that fail validation or the rate limit are answered with an
+// error event right away.
+func handleFrame(client *Client, codec *protocol.Codec, data []byte) {
+	presence.Touch(client.UserID)
+
+	envelope, command, frameErr := codec.Decode(data)
+	if frameErr == nil {
+		lib.RecordMessageIn(envelope.Type)
+		frameErr = allowFrame(client, envelope)
+	} else {
+		// Types of invalid frames are client input, kept out of the labels
+		lib.RecordMessageIn(invalidFrame)
+	}
+	if frameErr != nil {
+		client.Send(frameErr.Event(envelope.Room))
+		return
+	}
+	client.queueCommand(lib.Task[map[string]any]{Data: map[string]any{
+		"client":   client,
+		"envelope": envelope,
+		"command":  command,
+	}})
+}
@@ -0,0 +1,84 @@
+package chat
+
+import (
+	"errors"
+	"main/state"
+	"main/state/entity"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// directRoomID returns the ID of the direct conversation between two users.
+// IDs are sorted so both users address the same room.
+func directRoomID(userID string, otherUserID string) string {
+	ids := []string{userID, otherUserID}
+	slices.Sort(ids)
+	return "dm:" + strings.Join(ids, ":")
+}
+
+// OpenDirectHandler returns the direct conversation between the caller and
+// the :userId user, creating it on the first call.
+func OpenDirectHandler(c *gin.Context) {
+	userID, ok := requestUserID(c)
+	if !ok {
+		return
+	}
+	otherUserID := c.Param("userId")
+	if otherUserID == userID {
+		abortWithError(c, http.StatusBadRequest, "Can't open a conversation with yourself")
+		return
+	}
+
+	if uuid.Validate(otherUserID) != nil {
+		abortWithError(c, http.StatusNotFound, "User not found")
+		return
+	}
+	var other entity.User
+	err := state.GetByID[entity.User](state.GetConnection(), otherUserID, &other)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !other.Verified) {
+		abortWithError(c, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Failed to load user")
+		return
+	}
+
+	room := entity.ChatRoom{ID: directRoomID(userID, otherUserID), Kind: entity.ChatRoomKindDirect}
+	created := false
+	err = state.GetConnection().Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&room)
+		if result.Error != nil {
+			return result.Error
+		}
+		created = result.RowsAffected > 0
+
+		joinedAt := time.Now()
+		members := []entity.RoomMember{
+			{RoomID: room.ID, UserID: userID, Role: entity.RoomRoleMember, JoinedAt: joinedAt},
+			{RoomID: room.ID, UserID: otherUserID, Role: entity.RoomRoleMember, JoinedAt: joinedAt},
+		}
+		return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&members).Error
+	})
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Failed to open conversation")
+		return
+	}
+
+	if created {
+		hub.SubscribeUser(room.ID, userID)
+		hub.SubscribeUser(room.ID, otherUserID)
+	}
+	if err := state.GetByID[entity.ChatRoom](state.GetConnection(), room.ID, &room); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Failed to load conversation")
+		return
+	}
+	respondRoom(c, &room)
+}
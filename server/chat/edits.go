@@ -0,0 +1,142 @@
+package chat
+
+import (
+	"errors"
+	"main/chat/protocol"
+	"main/state"
+	"main/state/entity"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errMessageNotFound = protocol.NewError(protocol.ErrNotFound, "message not found")
+	errNotAuthor       = protocol.NewError(protocol.ErrForbidden, "only the author or a room admin can change this message")
+)
+
+// changeMessage locks the message, checks the user may change it and lets
+// apply modify it. The previous text is kept in message_edits and the updated
+// message is broadcast to the room as eventType. A non empty roomID restricts
+// the lookup to that room.
+func changeMessage(userID string, roomID string, messageID string, eventType string, apply func(*entity.Message, time.Time)) (*entity.Message, error) {
+	if uuid.Validate(messageID) != nil {
+		return nil, errMessageNotFound
+	}
+
+	var message entity.Message
+	err := state.GetConnection().Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&message, "id = ?", messageID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && (message.DeletedAt != nil || (roomID != "" && message.ChatRoomID != roomID))) {
+			return errMessageNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if message.AuthorID != userID {
+			member, err := getMember(tx, message.ChatRoomID, userID)
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errMessageNotFound
+			}
+			if err != nil {
+				return err
+			}
+			if member.Role != entity.RoomRoleOwner && member.Role != entity.RoomRoleAdmin {
+				return errNotAuthor
+			}
+		}
+
+		now := time.Now()
+		edit := entity.MessageEdit{
+			ID:           uuid.New().String(),
+			MessageID:    message.ID,
+			EditorID:     userID,
+			PreviousText: message.Text,
+			EditedAt:     now,
+		}
+		apply(&message, now)
+		edit.Deleted = message.DeletedAt != nil
+		if err := state.Create[entity.MessageEdit](tx, &edit); err != nil {
+			return err
+		}
+		return state.Update[entity.Message](tx, &message)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var payload any = toProtocolMessage(message)
+	if eventType == protocol.EventMessageDeleted {
+		payload = protocol.MessageDeletedPayload{MessageID: message.ID}
+	}
+	hub.Broadcast(message.ChatRoomID, protocol.NewEvent(eventType, message.ChatRoomID, payload))
+	return &message, nil
+}
+
+func editMessage(userID string, roomID string, messageID string, text string) (*entity.Message, error) {
+	return changeMessage(userID, roomID, messageID, protocol.EventMessageEdited, func(message *entity.Message, now time.Time) {
+		message.Text = text
+		message.EditedAt = &now
+	})
+}
+
+// deleteMessage turns the message into a tombstone, its text only survives
+// in the edit history.
+func deleteMessage(userID string, roomID string, messageID string) (*entity.Message, error) {
+	return changeMessage(userID, roomID, messageID, protocol.EventMessageDeleted, func(message *entity.Message, now time.Time) {
+		message.Text = ""
+		message.DeletedAt = &now
+	})
+}
+
+func EditMessageHandler(c *gin.Context) {
+	userID, ok := requestUserID(c)
+	if !ok {
+		return
+	}
+
+	var command protocol.EditPayload
+	if err := c.ShouldBindJSON(&command); err != nil {
+		abortWithError(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	command.MessageID = c.Param("id")
+	if err := command.Validate(); err != nil {
+		abortWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	message, err := editMessage(userID, "", command.MessageID, command.Text)
+	if err != nil {
+		abortWithProtocolError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "Success",
+		"data":   toProtocolMessage(*message),
+	})
+}
+
+func DeleteMessageHandler(c *gin.Context) {
+	userID, ok := requestUserID(c)
+	if !ok {
+		return
+	}
+
+	message, err := deleteMessage(userID, "", c.Param("id"))
+	if err != nil {
+		abortWithProtocolError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "Success",
+		"data":   toProtocolMessage(*message),
+	})
+}
@@ -0,0 +1,244 @@
+package chat
+
+import (
+	"io"
+	"main/chat/protocol"
+	"main/lib"
+	"main/session"
+	"main/state/entity"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// pollWait is how long a long-poll request waits for events before it
+	// returns empty handed.
+	pollWait = 25 * time.Second
+	// pollIdleTimeout is how long a long-poll connection survives without a
+	// poll request in flight.
+	pollIdleTimeout = 60 * time.Second
+	maxPollEvents   = 100
+)
+
+// pollState closes a long-poll client once no request has polled it for
+// pollIdleTimeout.
+type pollState struct {
+	mu     sync.Mutex
+	active int
+	idle   *time.Timer
+}
+
+// EventsHandler streams the events of a new connection as Server-Sent Events,
+// for clients that can't keep a WebSocket open. Every event is a `data:` line
+// holding the same JSON frame a WebSocket would get, the first one being
+// `connected` with the id to post commands with. The access token is read like
+// on /ws-upgrade, as EventSource can't set headers.
+func EventsHandler(c *gin.Context) {
+	if rejectDraining(c) {
+		return
+	}
+	user, ok := requestStreamUser(c)
+	if !ok {
+		return
+	}
+
+	config := getSocketConfig()
+	client := newClient(user.ID, nil, config)
+	disconnect := connect(client, c.Query("resume") == "1")
+	defer disconnect()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	connected := protocol.NewEvent(protocol.EventConnected, "", protocol.ConnectedPayload{ConnectionID: client.ID.String()})
+	if writeServerEvent(c, connected) != nil {
+		return
+	}
+
+	// Comments keep proxies from timing the stream out
+	ticker := time.NewTicker(config.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-client.outbox.ready:
+			for event, ok := client.outbox.pop(); ok; event, ok = client.outbox.pop() {
+				if writeServerEvent(c, event) != nil {
+					return
+				}
+			}
+		case <-ticker.C:
+			if _, err := io.WriteString(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+			c.Writer.Flush()
+		case <-client.done:
+			return
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+func writeServerEvent(c *gin.Context, event protocol.Event) error {
+	data, err := protocol.JSON.Encode(event)
+	if err != nil {
+		return err
+	}
+	if _, err := c.Writer.Write(append(append([]byte("data: "), data...), '\n', '\n')); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	lib.RecordMessageOut(event.Type)
+	return nil
+}
+
+// PollHandler returns the pending events of a long-poll connection, waiting up
+// to pollWait for one. Without connection_id a connection is opened and its
+// id returned along with its first events.
+func PollHandler(c *gin.Context) {
+	userID, ok := requestUserID(c)
+	if !ok {
+		return
+	}
+
+	client := hub.Client(userID, c.Query("connection_id"))
+	if c.Query("connection_id") == "" {
+		if rejectDraining(c) {
+			return
+		}
+		client = openPollClient(userID, c.Query("resume") == "1")
+	}
+	if client == nil || client.poll == nil || !client.poll.begin() {
+		abortWithError(c, http.StatusNotFound, "Connection not found, open a new one")
+		return
+	}
+	defer client.poll.end()
+
+	events, open := waitEvents(client, c)
+	if !open {
+		abortWithError(c, http.StatusGone, "Connection closed, open a new one")
+		return
+	}
+	for _, event := range events {
+		lib.RecordMessageOut(event.Type)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "Success",
+		"data": gin.H{
+			"connection_id": client.ID.String(),
+			"events":        events,
+		},
+	})
+}
+
+func openPollClient(userID string, resume bool) *Client {
+	client := newClient(userID, nil, getSocketConfig())
+	client.poll = &pollState{idle: time.AfterFunc(pollIdleTimeout, client.Close)}
+	disconnect := connect(client, resume)
+	go func() {
+		<-client.done
+		disconnect()
+	}()
+	return client
+}
+
+// begin keeps the client open while a poll is in flight. It reports false
+// when the client was closed for being idle.
+func (p *pollState) begin() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.active == 0 && !p.idle.Stop() {
+		return false
+	}
+	p.active++
+	return true
+}
+
+func (p *pollState) end() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.active--
+	if p.active == 0 {
+		p.idle.Reset(pollIdleTimeout)
+	}
+}
+
+// waitEvents takes up to maxPollEvents events from the client, waiting for
+// the first one. It reports false when the client was closed meanwhile.
+func waitEvents(client *Client, c *gin.Context) ([]protocol.Event, bool) {
+	timer := time.NewTimer(pollWait)
+	defer timer.Stop()
+
+	events := make([]protocol.Event, 0)
+	for {
+		for len(events) < maxPollEvents {
+			event, ok := client.outbox.pop()
+			if !ok {
+				break
+			}
+			events = append(events, event)
+		}
+		if len(events) > 0 {
+			return events, true
+		}
+
+		select {
+		case <-client.outbox.ready:
+		case <-timer.C:
+			return events, true
+		case <-client.done:
+			return nil, false
+		case <-c.Request.Context().Done():
+			return events, true
+		}
+	}
+}
+
+// CommandsHandler runs a command frame, in the WebSocket JSON format, for the
+// SSE or long-poll connection_id. Its ack or error arrives with the events of
+// the connection.
+func CommandsHandler(c *gin.Context) {
+	userID, ok := requestUserID(c)
+	if !ok {
+		return
+	}
+
+	client := hub.Client(userID, c.Query("connection_id"))
+	if client == nil || client.conn != nil {
+		abortWithError(c, http.StatusNotFound, "Connection not found")
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, client.config.maxMessageSize+1))
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if int64(len(data)) > client.config.maxMessageSize {
+		abortWithError(c, http.StatusRequestEntityTooLarge, "Frame too large")
+		return
+	}
+
+	handleFrame(client, protocol.JSON, data)
+	c.JSON(http.StatusAccepted, gin.H{
+		"status": "Success",
+	})
+}
+
+// requestStreamUser authenticates the access token of the request the way
+// /ws-upgrade does.
+func requestStreamUser(c *gin.Context) (*entity.User, bool) {
+	accessToken, _ := session.RequestAccessToken(c.Request)
+	user, _, reason := authenticate(accessToken)
+	if user == nil {
+		abortWithError(c, http.StatusUnauthorized, reason)
+		return nil, false
+	}
+	return user, true
+}
@@ -1,12 +1,152 @@
 package chat
 
 import (
+	"errors"
+	"main/chat/protocol"
 	"main/lib"
+	"main/state/entity"
 	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
 )
 
+var errNotMember = protocol.NewError(protocol.ErrForbidden, "not a member of this room")
+
+// ChatHandler runs a validated client command on the worker pool and answers
+// the frame with an ack, or with an error frame when the command failed.
 func ChatHandler(task lib.Task[map[string]any]) {
-	//fmt.Printf("task received %s", lib.PrettyPrint(task))
-	// Sends message over all connections in the room
-	time.Sleep(2 * time.Second)
+	client := task.Data["client"].(*Client)
+	envelope := task.Data["envelope"].(protocol.Envelope)
+	defer client.nextCommand()
+
+	var (
+		message *entity.Message
+		err     error
+	)
+	switch command := task.Data["command"].(type) {
+	case *protocol.SendPayload:
+		message, err = handleSend(client, envelope, command)
+	case *protocol.JoinPayload:
+		err = handleJoin(client, envelope)
+	case *protocol.LeavePayload:
+		hub.Unsubscribe(envelope.Room, client)
+	case *protocol.TypingPayload:
+		err = handleTyping(client, envelope, command)
+	case *protocol.AckPayload:
+		err = handleAck(client, envelope, command)
+	case *protocol.EditPayload:
+		message, err = editMessage(client.UserID, envelope.Room, command.MessageID, command.Text)
+	case *protocol.DeletePayload:
+		message, err = deleteMessage(client.UserID, envelope.Room, command.MessageID)
+	case *protocol.ResumePayload:
+		err = handleResume(client, command)
+	default:
+		lib.GetLogger().Debug("command not handled", zap.String("type", envelope.Type))
+		err = protocol.NewError(protocol.ErrUnknownType, "unknown frame type %q", envelope.Type)
+	}
+
+	if err != nil {
+		client.Send(commandError(err).WithRef(envelope.ID).Event(envelope.Room))
+		lib.GetLogger().Warn("command failed",
+			zap.String("type", envelope.Type),
+			zap.String("room", envelope.Room),
+			zap.String("userID", client.UserID),
+			zap.Error(err))
+		return
+	}
+
+	ack := protocol.AckEventPayload{Ref: envelope.ID}
+	if message != nil {
+		ack.MessageID = message.ID
+		ack.Seq = message.Seq
+	}
+	client.Send(protocol.NewEvent(protocol.EventAck, envelope.Room, ack))
+}
+
+// commandError returns err if it is meant for the client, anything else is
+// reported as internal without details.
+func commandError(err error) *protocol.Error {
+	var protocolErr *protocol.Error
+	if errors.As(err, &protocolErr) {
+		return protocolErr
+	}
+	return protocol.NewError(protocol.ErrInternal, "command failed, try again later")
+}
+
+// handleSend stores and broadcasts a message. A retried send, recognized by
+// its client message id, is answered with the stored message to the sending
+// connection only. The lock spares most retries a failed insert, the unique
+// index catches those racing on another server.
+func handleSend(client *Client, envelope protocol.Envelope, command *protocol.SendPayload) (*entity.Message, error) {
+	if !isMember(envelope.Room, client.UserID) {
+		return nil, errNotMember
+	}
+
+	unlock := sends.lock(client.UserID, command.ClientMsgID)
+	defer unlock()
+	message, err := findSentMessage(client.UserID, command.ClientMsgID, time.Now().Add(-sendDedupWindow()))
+	if err != nil {
+		return nil, err
+	}
+	if message == nil {
+		var stored bool
+		message, stored, err = storeMessage(envelope.Room, client.UserID, command.Text, command.ClientMsgID)
+		if err != nil {
+			return nil, err
+		}
+		if stored {
+			typing.Stop(envelope.Room, client.UserID)
+			hub.Broadcast(envelope.Room, protocol.NewEvent(protocol.EventMessageNew, envelope.Room, toProtocolMessage(*message)))
+			return message, nil
+		}
+	}
+
+	if message.ChatRoomID != envelope.Room {
+		return nil, errClientMsgIDReused
+	}
+	client.Send(protocol.NewEvent(protocol.EventMessageNew, envelope.Room, toProtocolMessage(*message)))
+	return message, nil
+}
+
+func handleJoin(client *Client, envelope protocol.Envelope) error {
+	if !isMember(envelope.Room, client.UserID) {
+		return errNotMember
+	}
+
+	hub.Subscribe(envelope.Room, client)
+	return nil
+}
+
+// handleAck queues a delivered or read receipt. Subscription to the room
+// stands for membership here, acks are too frequent to hit the database for.
+func handleAck(client *Client, envelope protocol.Envelope, command *protocol.AckPayload) error {
+	if !hub.IsSubscribed(envelope.Room, client) {
+		return errNotMember
+	}
+	if uuid.Validate(command.MessageID) != nil {
+		return protocol.NewError(protocol.ErrNotFound, "message not found")
+	}
+
+	receipts.Add(receipt{
+		MessageID: command.MessageID,
+		RoomID:    envelope.Room,
+		UserID:    client.UserID,
+		Status:    command.Status,
+		At:        time.Now(),
+	})
+	return nil
+}
+
+func handleTyping(client *Client, envelope protocol.Envelope, command *protocol.TypingPayload) error {
+	if !hub.IsSubscribed(envelope.Room, client) {
+		return errNotMember
+	}
+
+	if command.State == protocol.TypingStart {
+		typing.Start(envelope.Room, client.UserID)
+	} else {
+		typing.Stop(envelope.Room, client.UserID)
+	}
+	return nil
 }
@@ -0,0 +1,90 @@
+package chat
+
+import (
+	"errors"
+	"main/chat/protocol"
+	"main/lib"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChatHandler(t *testing.T) {
+	t.Run("Command is acknowledged with the frame id", func(t *testing.T) {
+		client := newClient("alice", nil, getSocketConfig())
+		envelope := protocol.Envelope{V: protocol.Version, Type: protocol.CommandLeave, ID: "c-1", Room: "general"}
+
+		ChatHandler(lib.Task[map[string]any]{Data: map[string]any{
+			"client":   client,
+			"envelope": envelope,
+			"command":  &protocol.LeavePayload{},
+		}})
+
+		event, _ := client.outbox.pop()
+		assert.Equal(t, protocol.EventAck, event.Type)
+		assert.Equal(t, protocol.AckEventPayload{Ref: "c-1"}, event.Payload)
+	})
+
+	t.Run("Failed command is answered with an error frame", func(t *testing.T) {
+		client := newClient("alice", nil, getSocketConfig())
+		envelope := protocol.Envelope{V: protocol.Version, Type: protocol.CommandTyping, ID: "c-2", Room: "general"}
+
+		ChatHandler(lib.Task[map[string]any]{Data: map[string]any{
+			"client":   client,
+			"envelope": envelope,
+			"command":  &protocol.TypingPayload{State: protocol.TypingStart},
+		}})
+
+		event, _ := client.outbox.pop()
+		assert.Equal(t, protocol.EventError, event.Type)
+		assert.Equal(t, &protocol.Error{Code: protocol.ErrForbidden, Message: errNotMember.Message, Ref: "c-2"}, event.Payload)
+	})
+
+	t.Run("Unexpected errors are reported as internal", func(t *testing.T) {
+		assert.Equal(t, protocol.ErrInternal, commandError(errors.New("connection refused")).Code)
+		assert.Equal(t, errNotMember, commandError(errNotMember))
+	})
+}
+
+func TestQueueCommand(t *testing.T) {
+	t.Run("Commands of a client run in the order they were sent", func(t *testing.T) {
+		client := newClient("alice", nil, getSocketConfig())
+		var (
+			mu    sync.Mutex
+			order []int
+		)
+		done := make(chan struct{})
+		wp := lib.NewWorkerPool(lib.WorkerPoolConfig{NumWorkers: 8, WorkerFn: func(task lib.Task[map[string]any]) {
+			defer task.Data["client"].(*Client).nextCommand()
+			n := task.Data["n"].(int)
+			// Earlier commands take longer, free workers must not overtake them
+			time.Sleep(time.Duration(10-n) * time.Millisecond)
+			mu.Lock()
+			order = append(order, n)
+			if len(order) == 10 {
+				close(done)
+			}
+			mu.Unlock()
+		}})
+		wp.ScaleUp(8)
+		previous := lib.GetConfig().WP
+		lib.GetConfig().WP = wp
+		t.Cleanup(func() {
+			wp.Drain(time.Second)
+			lib.GetConfig().WP = previous
+		})
+
+		for n := 0; n < 10; n++ {
+			client.queueCommand(lib.Task[map[string]any]{Data: map[string]any{"client": client, "n": n}})
+		}
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("commands didn't run")
+		}
+		assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, order)
+	})
+}
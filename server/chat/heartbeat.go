@@ -0,0 +1,95 @@
+package chat
+
+import (
+	"compress/flate"
+	"errors"
+	"main/lib"
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// Eviction reasons recorded by lib.RecordWebSocketEviction.
+const (
+	evictedHeartbeat    = "heartbeat"
+	evictedTooBig       = "message_too_big"
+	evictedWriteFailed  = "write_failed"
+	evictedSlowConsumer = "slow_consumer"
+)
+
+// socketConfig holds the heartbeat and limits applied to every socket.
+type socketConfig struct {
+	// pingInterval is how often the server pings the client.
+	pingInterval time.Duration
+	// pongWait is how long the connection may stay silent, pongs included,
+	// before it is considered dead. It has to exceed pingInterval.
+	pongWait time.Duration
+	// writeWait is how long a single write may take.
+	writeWait time.Duration
+	// maxMessageSize is the largest frame accepted from the client, in bytes.
+	maxMessageSize int64
+	// outboxSize is how many events may wait to be written to the client,
+	// and outboxPolicy what happens when that is exceeded.
+	outboxSize   int
+	outboxPolicy string
+	// compressionLevel is the flate level of outgoing frames when the client
+	// negotiated compression, 0 turning it off.
+	compressionLevel int
+}
+
+func getSocketConfig() socketConfig {
+	config := socketConfig{
+		pingInterval:   time.Duration(lib.GetIntDotEnvDefault("WS_PING_INTERVAL_SECONDS", 25)) * time.Second,
+		pongWait:       time.Duration(lib.GetIntDotEnvDefault("WS_PONG_WAIT_SECONDS", 60)) * time.Second,
+		writeWait:      time.Duration(lib.GetIntDotEnvDefault("WS_WRITE_WAIT_SECONDS", 10)) * time.Second,
+		maxMessageSize: int64(lib.GetIntDotEnvDefault("WS_MAX_MESSAGE_BYTES", 128*1024)),
+		outboxSize:     lib.GetIntDotEnvDefault("WS_OUTBOX_SIZE", 256),
+		outboxPolicy:   string(lib.GetDotEnv("WS_OUTBOX_POLICY")),
+		// Frames are small, higher levels cost more CPU than they save
+		compressionLevel: lib.GetIntDotEnvDefault("WS_COMPRESSION_LEVEL", flate.BestSpeed),
+	}
+	if config.outboxPolicy != OutboxDisconnect {
+		config.outboxPolicy = OutboxShed
+	}
+	if config.pongWait <= config.pingInterval {
+		config.pongWait = 2 * config.pingInterval
+	}
+	return config
+}
+
+// prepareRead applies the read limit and deadline to the connection. Every
+// frame and every pong pushes the deadline back.
+func prepareRead(conn *websocket.Conn, config socketConfig) {
+	conn.SetReadLimit(config.maxMessageSize)
+	_ = conn.SetReadDeadline(time.Now().Add(config.pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(config.pongWait))
+	})
+}
+
+// prepareCompression applies the compression level to the connection. It has
+// no effect unless the client negotiated permessage-deflate.
+func prepareCompression(conn *websocket.Conn, config socketConfig) {
+	if config.compressionLevel == 0 {
+		conn.EnableWriteCompression(false)
+		return
+	}
+	if err := conn.SetCompressionLevel(config.compressionLevel); err != nil {
+		lib.GetLogger().Warn("invalid WS_COMPRESSION_LEVEL", zap.Error(err))
+	}
+}
+
+// evictionReason tells why the read loop ended, or returns an empty string
+// when the client went away on its own.
+func evictionReason(err error) string {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return evictedHeartbeat
+	}
+	if errors.Is(err, websocket.ErrReadLimit) {
+		return evictedTooBig
+	}
+	return ""
+}
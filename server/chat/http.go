@@ -0,0 +1,48 @@
+package chat
+
+import (
+	"errors"
+	"main/chat/protocol"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestUserID returns the user authenticated by session.AuthMiddleware,
+// aborting with 401 when there is none.
+func requestUserID(c *gin.Context) (string, bool) {
+	userID := c.GetString("userID")
+	if userID == "" {
+		abortWithError(c, http.StatusUnauthorized, "Unauthorized")
+		return "", false
+	}
+	return userID, true
+}
+
+func abortWithError(c *gin.Context, status int, message string) {
+	c.AbortWithStatusJSON(status, gin.H{
+		"status":  "Error",
+		"message": message,
+	})
+}
+
+// abortWithProtocolError answers with the HTTP status matching a command
+// error, so REST and WebSocket callers of the same operation fail alike.
+func abortWithProtocolError(c *gin.Context, err error) {
+	var protocolErr *protocol.Error
+	if !errors.As(err, &protocolErr) {
+		abortWithError(c, http.StatusInternalServerError, "Internal server error")
+		return
+	}
+
+	status := http.StatusBadRequest
+	switch protocolErr.Code {
+	case protocol.ErrForbidden:
+		status = http.StatusForbidden
+	case protocol.ErrNotFound:
+		status = http.StatusNotFound
+	case protocol.ErrInternal:
+		status = http.StatusInternalServerError
+	}
+	abortWithError(c, status, protocolErr.Message)
+}
@@ -0,0 +1,376 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"main/broker"
+	"main/chat/protocol"
+	"main/lib"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// Topics of the broker, a room topic carries the events of the room and a
+// user topic those sent to the user along with their subscription changes.
+// Every hub subscribes to the presence topic.
+const (
+	roomTopicPrefix = "room:"
+	userTopicPrefix = "user:"
+	presenceTopic   = "presence"
+)
+
+// Kinds of hubMessage.
+const (
+	hubEvent       = "event"
+	hubSubscribe   = "subscribe"
+	hubUnsubscribe = "unsubscribe"
+	hubCloseRoom   = "close_room"
+	hubPresence    = "presence"
+)
+
+// brokerTimeout bounds the broker calls, subscriptions being changed with the
+// hub locked.
+const brokerTimeout = 2 * time.Second
+
+// hubMessage is what hubs publish to each other through the broker.
+type hubMessage struct {
+	Kind string `json:"kind"`
+	// Room is the room subscribed to or unsubscribed from on a user topic
+	Room string `json:"room,omitempty"`
+	// Except names the user whose devices don't get the event
+	Except   string           `json:"except,omitempty"`
+	Event    json.RawMessage  `json:"event,omitempty"`
+	Presence []presenceReport `json:"presence,omitempty"`
+}
+
+// Hub tracks connected clients by user and by the rooms they subscribed to,
+// and fans events out to them. Events and subscription changes go through the
+// broker, so they reach the clients connected to every server; the hub only
+// subscribes to the topics of its own clients.
+type Hub struct {
+	mu       sync.RWMutex
+	rooms    map[string]map[*Client]struct{}
+	users    map[string]map[*Client]struct{}
+	broker   broker.Broker
+	presence *presenceTracker
+}
+
+var hub = NewHub()
+
+// NewHub returns a hub on an in-memory broker, reaching the clients of this
+// server only until UseBroker is called.
+func NewHub() *Hub {
+	h := &Hub{
+		rooms: make(map[string]map[*Client]struct{}),
+		users: make(map[string]map[*Client]struct{}),
+	}
+	h.presence = newPresenceTracker(h, uuid.NewString())
+	h.broker = broker.NewMemory(h.receive)
+	h.subscribeTopic(presenceTopic)
+	return h
+}
+
+func GetHub() *Hub {
+	return hub
+}
+
+// UseBroker replaces the broker of the hub with the one returned by open,
+// before any client connects.
+func (h *Hub) UseBroker(open func(broker.Handler) (broker.Broker, error)) error {
+	b, err := open(h.receive)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	previous := h.broker
+	h.broker = b
+	h.subscribeTopic(presenceTopic)
+	return previous.Close()
+}
+
+// Broker returns the broker the hub publishes to.
+func (h *Hub) Broker() broker.Broker {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.broker
+}
+
+func (h *Hub) Register(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.users[client.UserID] == nil {
+		h.users[client.UserID] = make(map[*Client]struct{})
+		h.subscribeTopic(userTopicPrefix + client.UserID)
+	}
+	h.users[client.UserID][client] = struct{}{}
+	lib.RecordWebSocketConnection(true)
+}
+
+// Unregister removes the client from the hub and every room it subscribed to.
+func (h *Hub) Unregister(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.users[client.UserID][client]; !ok {
+		return
+	}
+	for room := range client.rooms {
+		h.removeFromRoom(room, client)
+	}
+	delete(h.users[client.UserID], client)
+	if len(h.users[client.UserID]) == 0 {
+		delete(h.users, client.UserID)
+		h.unsubscribeTopic(userTopicPrefix + client.UserID)
+	}
+	lib.RecordWebSocketConnection(false)
+}
+
+func (h *Hub) Subscribe(room string, client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.rooms[room] == nil {
+		h.rooms[room] = make(map[*Client]struct{})
+		h.subscribeTopic(roomTopicPrefix + room)
+	}
+	h.rooms[room][client] = struct{}{}
+	client.rooms[room] = struct{}{}
+}
+
+func (h *Hub) Unsubscribe(room string, client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.removeFromRoom(room, client)
+}
+
+// SubscribeUser subscribes every connected device of the user to the room,
+// on every server.
+func (h *Hub) SubscribeUser(room string, userID string) {
+	h.publish(userTopicPrefix+userID, hubMessage{Kind: hubSubscribe, Room: room})
+}
+
+// UnsubscribeUser unsubscribes every connected device of the user from the
+// room, on every server.
+func (h *Hub) UnsubscribeUser(room string, userID string) {
+	h.publish(userTopicPrefix+userID, hubMessage{Kind: hubUnsubscribe, Room: room})
+}
+
+// CloseRoom unsubscribes every client from the room, on every server.
+func (h *Hub) CloseRoom(room string) {
+	h.publish(roomTopicPrefix+room, hubMessage{Kind: hubCloseRoom})
+}
+
+// PublishPresence sends the presence reports of this server to every server.
+func (h *Hub) PublishPresence(reports []presenceReport) {
+	h.publish(presenceTopic, hubMessage{Kind: hubPresence, Presence: reports})
+}
+
+// Broadcast sends the event to every client subscribed to the room.
+func (h *Hub) Broadcast(room string, event protocol.Event) {
+	h.publishEvent(roomTopicPrefix+room, "", event)
+}
+
+// BroadcastExcept sends the event to every client subscribed to the room,
+// except for the devices of userID.
+func (h *Hub) BroadcastExcept(room string, userID string, event protocol.Event) {
+	h.publishEvent(roomTopicPrefix+room, userID, event)
+}
+
+// SendToUser sends the event to every connected device of the user.
+func (h *Hub) SendToUser(userID string, event protocol.Event) {
+	h.publishEvent(userTopicPrefix+userID, "", event)
+}
+
+func (h *Hub) IsSubscribed(room string, client *Client) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	_, ok := client.rooms[room]
+	return ok
+}
+
+func (h *Hub) RoomClients(room string) []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return collectClients(h.rooms[room])
+}
+
+func (h *Hub) UserClients(userID string) []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return collectClients(h.users[userID])
+}
+
+// Clients returns every client connected to this server.
+func (h *Hub) Clients() []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var clients []*Client
+	for _, set := range h.users {
+		clients = append(clients, collectClients(set)...)
+	}
+	return clients
+}
+
+// Client returns the connected device of the user with the given client id,
+// or nil when there is none.
+func (h *Hub) Client(userID string, clientID string) *Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for client := range h.users[userID] {
+		if client.ID.String() == clientID {
+			return client
+		}
+	}
+	return nil
+}
+
+// UserRooms returns the rooms any device of the user is subscribed to.
+func (h *Hub) UserRooms(userID string) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	rooms := make(map[string]struct{})
+	for client := range h.users[userID] {
+		for room := range client.rooms {
+			rooms[room] = struct{}{}
+		}
+	}
+	roomIDs := make([]string, 0, len(rooms))
+	for room := range rooms {
+		roomIDs = append(roomIDs, room)
+	}
+	return roomIDs
+}
+
+// removeFromRoom expects h.mu to be held.
+func (h *Hub) removeFromRoom(room string, client *Client) {
+	if _, ok := h.rooms[room][client]; !ok {
+		return
+	}
+	delete(h.rooms[room], client)
+	if len(h.rooms[room]) == 0 {
+		delete(h.rooms, room)
+		h.unsubscribeTopic(roomTopicPrefix + room)
+	}
+	delete(client.rooms, room)
+}
+
+func (h *Hub) publishEvent(topic string, except string, event protocol.Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		lib.GetLogger().Error("failed to encode event", zap.String("type", event.Type), zap.Error(err))
+		return
+	}
+	h.publish(topic, hubMessage{Kind: hubEvent, Except: except, Event: data})
+}
+
+// publish sends the message to the hubs subscribed to the topic. When the
+// broker fails, the clients of this server still get it.
+func (h *Hub) publish(topic string, message hubMessage) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		lib.GetLogger().Error("failed to encode hub message", zap.String("topic", topic), zap.Error(err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), brokerTimeout)
+	defer cancel()
+	if err := h.Broker().Publish(ctx, topic, data); err != nil {
+		lib.GetLogger().Error("failed to publish", zap.String("topic", topic), zap.Error(err))
+		h.receive(topic, data)
+	}
+}
+
+// receive handles a message published by any hub to a topic this one
+// subscribed to.
+func (h *Hub) receive(topic string, data []byte) {
+	var message hubMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		lib.GetLogger().Error("invalid hub message", zap.String("topic", topic), zap.Error(err))
+		return
+	}
+
+	if topic == presenceTopic {
+		if message.Kind == hubPresence {
+			h.presence.receive(message.Presence)
+		}
+		return
+	}
+
+	var clients []*Client
+	if room, ok := strings.CutPrefix(topic, roomTopicPrefix); ok {
+		clients = h.RoomClients(room)
+	} else if userID, ok := strings.CutPrefix(topic, userTopicPrefix); ok {
+		clients = h.UserClients(userID)
+	} else {
+		lib.GetLogger().Warn("message on unknown topic", zap.String("topic", topic))
+		return
+	}
+
+	switch message.Kind {
+	case hubEvent:
+		event, err := protocol.DecodeEvent(message.Event)
+		if err != nil {
+			lib.GetLogger().Error("invalid event", zap.String("topic", topic), zap.Error(err))
+			return
+		}
+		for _, client := range clients {
+			if client.UserID != message.Except {
+				client.Send(event)
+			}
+		}
+	case hubSubscribe:
+		for _, client := range clients {
+			h.Subscribe(message.Room, client)
+		}
+	case hubUnsubscribe:
+		for _, client := range clients {
+			h.Unsubscribe(message.Room, client)
+		}
+	case hubCloseRoom:
+		room := strings.TrimPrefix(topic, roomTopicPrefix)
+		for _, client := range clients {
+			h.Unsubscribe(room, client)
+		}
+	}
+}
+
+// subscribeTopic expects h.mu to be held.
+func (h *Hub) subscribeTopic(topic string) {
+	ctx, cancel := context.WithTimeout(context.Background(), brokerTimeout)
+	defer cancel()
+	if err := h.broker.Subscribe(ctx, topic); err != nil {
+		lib.GetLogger().Error("failed to subscribe", zap.String("topic", topic), zap.Error(err))
+	}
+}
+
+// unsubscribeTopic expects h.mu to be held.
+func (h *Hub) unsubscribeTopic(topic string) {
+	ctx, cancel := context.WithTimeout(context.Background(), brokerTimeout)
+	defer cancel()
+	if err := h.broker.Unsubscribe(ctx, topic); err != nil {
+		lib.GetLogger().Error("failed to unsubscribe", zap.String("topic", topic), zap.Error(err))
+	}
+}
+
+func collectClients(set map[*Client]struct{}) []*Client {
+	clients := make([]*Client, 0, len(set))
+	for client := range set {
+		clients = append(clients, client)
+	}
+	return clients
+}
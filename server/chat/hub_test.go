@@ -0,0 +1,110 @@
+package chat
+
+import (
+	"main/broker"
+	"main/chat/protocol"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHub(t *testing.T) {
+	t.Run("Broadcast reaches every device of every subscriber", func(t *testing.T) {
+		h := NewHub()
+		phone, laptop, other := newClient("alice", nil, getSocketConfig()), newClient("alice", nil, getSocketConfig()), newClient("bob", nil, getSocketConfig())
+		for _, client := range []*Client{phone, laptop, other} {
+			h.Register(client)
+		}
+		h.SubscribeUser("general", "alice")
+		h.Subscribe("general", other)
+
+		h.Broadcast("general", protocol.NewEvent(protocol.EventMessageNew, "general", nil))
+
+		for _, client := range []*Client{phone, laptop, other} {
+			assert.Equal(t, 1, client.outbox.len())
+		}
+	})
+
+	t.Run("Unregister drops room subscriptions", func(t *testing.T) {
+		h := NewHub()
+		client := newClient("alice", nil, getSocketConfig())
+		h.Register(client)
+		h.Subscribe("general", client)
+
+		h.Unregister(client)
+
+		assert.Empty(t, h.RoomClients("general"))
+		assert.Empty(t, h.UserClients("alice"))
+	})
+
+	t.Run("Slow client is closed instead of blocking the room", func(t *testing.T) {
+		h := NewHub()
+		slow, fast := newClient("alice", nil, getSocketConfig()), newClient("bob", nil, getSocketConfig())
+		h.Register(slow)
+		h.Register(fast)
+		h.Subscribe("general", slow)
+		h.Subscribe("general", fast)
+
+		for i := 0; i <= slow.config.outboxSize; i++ {
+			h.Broadcast("general", protocol.NewEvent(protocol.EventMessageNew, "general", nil))
+			fast.outbox.pop()
+		}
+
+		assert.False(t, slow.Send(protocol.NewEvent(protocol.EventMessageNew, "general", nil)))
+		assert.Equal(t, CloseSlowConsumer, slow.closeCode)
+		assert.True(t, fast.Send(protocol.NewEvent(protocol.EventMessageNew, "general", nil)))
+	})
+
+	t.Run("Held events follow the replay without duplicates", func(t *testing.T) {
+		h := NewHub()
+		client := newClient("alice", nil, getSocketConfig())
+		h.Register(client)
+		h.Subscribe("general", client)
+
+		client.beginReplay()
+		h.Broadcast("general", protocol.NewEvent(protocol.EventMessageNew, "general", protocol.Message{ID: "m-2"}))
+		h.Broadcast("general", protocol.NewEvent(protocol.EventMessageNew, "general", protocol.Message{ID: "m-3"}))
+		assert.Zero(t, client.outbox.len())
+
+		client.replay(protocol.NewEvent(protocol.EventMessageNew, "general", protocol.Message{ID: "m-1"}))
+		client.replay(protocol.NewEvent(protocol.EventMessageNew, "general", protocol.Message{ID: "m-2"}))
+		client.endReplay(func(event protocol.Event) bool {
+			return event.Payload.(protocol.Message).ID == "m-2"
+		})
+
+		var ids []string
+		for event, ok := client.outbox.pop(); ok; event, ok = client.outbox.pop() {
+			ids = append(ids, event.Payload.(protocol.Message).ID)
+		}
+		assert.Equal(t, []string{"m-1", "m-2", "m-3"}, ids)
+	})
+
+	t.Run("Events and subscriptions reach the clients of every server", func(t *testing.T) {
+		bus := broker.NewMemoryBus()
+		first, second := NewHub(), NewHub()
+		for _, h := range []*Hub{first, second} {
+			assert.NoError(t, h.UseBroker(func(handler broker.Handler) (broker.Broker, error) {
+				return bus.Connect(handler), nil
+			}))
+		}
+		alice, bob := newClient("alice", nil, getSocketConfig()), newClient("bob", nil, getSocketConfig())
+		first.Register(alice)
+		second.Register(bob)
+		first.Subscribe("general", alice)
+
+		first.SubscribeUser("general", "bob")
+		first.Broadcast("general", protocol.NewEvent(protocol.EventMessageNew, "general", protocol.Message{ID: "m-1"}))
+		second.BroadcastExcept("general", "bob", protocol.NewEvent(protocol.EventTypingStart, "general", protocol.TypingEventPayload{UserID: "bob"}))
+
+		assert.True(t, second.IsSubscribed("general", bob))
+		event, _ := bob.outbox.pop()
+		assert.Equal(t, protocol.Message{ID: "m-1"}, event.Payload)
+		assert.Zero(t, bob.outbox.len())
+		assert.Equal(t, 2, alice.outbox.len())
+
+		second.CloseRoom("general")
+
+		assert.Empty(t, first.RoomClients("general"))
+		assert.Empty(t, second.RoomClients("general"))
+	})
+}
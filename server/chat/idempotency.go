@@ -0,0 +1,133 @@
+package chat
+
+import (
+	"errors"
+	"main/chat/protocol"
+	"main/lib"
+	"main/state"
+	"main/state/entity"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// defaultSendDedupWindow is how long a client message id identifies a send
+// unless SEND_DEDUP_WINDOW_SECONDS says otherwise.
+const defaultSendDedupWindow = 10 * time.Minute
+
+var errClientMsgIDReused = protocol.NewError(protocol.ErrInvalidPayload, "client_msg_id was already used in another room")
+
+type sendKey struct {
+	userID      string
+	clientMsgID string
+}
+
+type sendLock struct {
+	mu      sync.Mutex
+	waiters int
+}
+
+// sendLocks serializes sends sharing a client message id within the process,
+// so a retry racing the original send finds it stored instead of failing its
+// insert on the unique index, which alone guards sends across servers.
+type sendLocks struct {
+	mu    sync.Mutex
+	locks map[sendKey]*sendLock
+}
+
+var sends = &sendLocks{locks: make(map[sendKey]*sendLock)}
+
+// lock returns the function releasing the lock.
+func (l *sendLocks) lock(userID string, clientMsgID string) func() {
+	key := sendKey{userID: userID, clientMsgID: clientMsgID}
+
+	l.mu.Lock()
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &sendLock{}
+		l.locks[key] = lock
+	}
+	lock.waiters++
+	l.mu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+
+		l.mu.Lock()
+		lock.waiters--
+		if lock.waiters == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
+
+var (
+	dedupWindowOnce sync.Once
+	dedupWindow     time.Duration
+)
+
+// InitConfiguration reads the settings of the chat, panicking on invalid ones
+// at startup rather than on the first command using them.
+func InitConfiguration() {
+	sendDedupWindow()
+}
+
+// sendDedupWindow is how long a client message id identifies a send, after
+// which it may be used again.
+func sendDedupWindow() time.Duration {
+	dedupWindowOnce.Do(func() {
+		seconds := lib.GetIntDotEnvDefault("SEND_DEDUP_WINDOW_SECONDS", int(defaultSendDedupWindow/time.Second))
+		if seconds <= 0 {
+			panic("Invalid SEND_DEDUP_WINDOW_SECONDS value")
+		}
+		dedupWindow = time.Duration(seconds) * time.Second
+	})
+	return dedupWindow
+}
+
+// clientMsgIDIndex is the unique index rejecting a second message of the
+// same author with the same client message id, ids older than the dedup
+// window being released by releaseClientMsgID.
+const clientMsgIDIndex = "messages_author_client_msg_id_idx"
+
+// uniqueViolation is the Postgres error code of a unique constraint failure.
+const uniqueViolation = "23505"
+
+// findSentMessage returns the message the user sent with clientMsgID after
+// since, or nil when there is none.
+func findSentMessage(userID string, clientMsgID string, since time.Time) (*entity.Message, error) {
+	var message entity.Message
+	err := state.GetConnection().
+		Where("author_id = ? AND client_msg_id = ? AND sent_at > ?", userID, clientMsgID, since).
+		Order("sent_at DESC").
+		First(&message).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// isDuplicateSend tells whether err is the unique index rejecting a message
+// the author already stored.
+func isDuplicateSend(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation && pgErr.ConstraintName == clientMsgIDIndex
+}
+
+// releaseClientMsgID clears the client message id of the message when it was
+// sent before the dedup window, so the id can identify a new send. It reports
+// whether the id was released.
+func releaseClientMsgID(message *entity.Message) (bool, error) {
+	result := state.GetConnection().
+		Model(&entity.Message{}).
+		Where("id = ? AND sent_at <= ?", message.ID, time.Now().Add(-sendDedupWindow())).
+		Update("client_msg_id", "")
+	return result.RowsAffected > 0, result.Error
+}
@@ -0,0 +1,24 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsDuplicateSend(t *testing.T) {
+	t.Run("Unique violation of the client message id index is a duplicate", func(t *testing.T) {
+		err := fmt.Errorf("insert: %w", &pgconn.PgError{Code: uniqueViolation, ConstraintName: clientMsgIDIndex})
+
+		assert.True(t, isDuplicateSend(err))
+	})
+
+	t.Run("Other failures aren't duplicates", func(t *testing.T) {
+		assert.False(t, isDuplicateSend(nil))
+		assert.False(t, isDuplicateSend(errors.New("connection reset")))
+		assert.False(t, isDuplicateSend(&pgconn.PgError{Code: uniqueViolation, ConstraintName: "messages_pkey"}))
+	})
+}
@@ -0,0 +1,83 @@
+package chat
+
+import (
+	"errors"
+	"main/chat/protocol"
+	"main/state"
+	"main/state/entity"
+
+	"gorm.io/gorm"
+)
+
+// getMember returns the membership of the user in the room, or
+// gorm.ErrRecordNotFound when the user doesn't belong to it.
+func getMember(db *gorm.DB, roomID string, userID string) (*entity.RoomMember, error) {
+	var member entity.RoomMember
+	if err := db.First(&member, "room_id = ? AND user_id = ?", roomID, userID).Error; err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+func isMember(roomID string, userID string) bool {
+	_, err := getMember(state.GetConnection(), roomID, userID)
+	return err == nil
+}
+
+// sharingRoom returns those of userIDs who are members of a room the user is
+// a member of.
+func sharingRoom(db *gorm.DB, userID string, userIDs []string) (map[string]struct{}, error) {
+	var peers []string
+	err := db.Table("room_members AS peer").
+		Joins("JOIN room_members AS self ON self.room_id = peer.room_id AND self.user_id = ?", userID).
+		Where("peer.user_id IN ?", userIDs).
+		Distinct().
+		Pluck("peer.user_id", &peers).Error
+	if err != nil {
+		return nil, err
+	}
+	shared := make(map[string]struct{}, len(peers))
+	for _, peer := range peers {
+		shared[peer] = struct{}{}
+	}
+	return shared, nil
+}
+
+// listMembers returns the members of the room, longest standing first.
+func listMembers(db *gorm.DB, roomID string) ([]entity.RoomMember, error) {
+	var members []entity.RoomMember
+	err := state.List[entity.RoomMember](db.Where("room_id = ?", roomID).Order("joined_at, user_id"), &members)
+	return members, err
+}
+
+// userRoomIDs returns the IDs of the rooms the user belongs to.
+func userRoomIDs(userID string) ([]string, error) {
+	var roomIDs []string
+	err := state.GetConnection().Model(&entity.RoomMember{}).Where("user_id = ?", userID).Pluck("room_id", &roomIDs).Error
+	return roomIDs, err
+}
+
+// promoteSuccessor hands ownership of the room to the longest standing admin,
+// or member when there is no admin left. It expects to run in the transaction
+// that removed the previous owner.
+func promoteSuccessor(tx *gorm.DB, roomID string) error {
+	var successor entity.RoomMember
+	err := tx.Where("room_id = ?", roomID).
+		Order("role = '" + entity.RoomRoleAdmin + "' DESC, joined_at").
+		First(&successor).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Model(&successor).Update("role", entity.RoomRoleOwner).Error
+}
+
+func toProtocolMembers(members []entity.RoomMember) []protocol.Member {
+	items := make([]protocol.Member, 0, len(members))
+	for _, member := range members {
+		items = append(items, protocol.Member{UserID: member.UserID, Role: member.Role, JoinedAt: member.JoinedAt})
+	}
+	return items
+}
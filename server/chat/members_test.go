@@ -0,0 +1,24 @@
+package chat
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharingRoom(t *testing.T) {
+	t.Run("Only users of the caller's rooms are returned", func(t *testing.T) {
+		db, mock := mockDB(t)
+		mock.ExpectQuery(regexp.QuoteMeta(`SELECT DISTINCT "peer"."user_id" FROM room_members AS peer JOIN room_members AS self ON self.room_id = peer.room_id AND self.user_id = $1 WHERE peer.user_id IN ($2,$3)`)).
+			WithArgs("alice", "bob", "mallory").
+			WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("bob"))
+
+		shared, err := sharingRoom(db, "alice", []string{"bob", "mallory"})
+
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]struct{}{"bob": {}}, shared)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
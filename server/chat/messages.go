@@ -0,0 +1,197 @@
+package chat
+
+import (
+	"encoding/base64"
+	"errors"
+	"main/chat/protocol"
+	"main/state"
+	"main/state/entity"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+var (
+	errInvalidCursor = errors.New("invalid cursor")
+	errRoomNotFound  = protocol.NewError(protocol.ErrNotFound, "room not found")
+)
+
+// messageCursor points at a message by its sequence number in the room.
+type messageCursor int64
+
+func (cursor messageCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(int64(cursor), 10)))
+}
+
+func parseCursor(value string) (messageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return 0, errInvalidCursor
+	}
+	seq, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil || seq < 0 {
+		return 0, errInvalidCursor
+	}
+	return messageCursor(seq), nil
+}
+
+// MessagesHandler returns a page of the room history, oldest message first.
+// Without cursors the latest page is returned; `before` pages back in time and
+// `after` pages forward. Deleted messages are returned as tombstones.
+func MessagesHandler(c *gin.Context) {
+	userID, ok := requestUserID(c)
+	if !ok {
+		return
+	}
+
+	roomID := c.Query("room")
+	if roomID == "" {
+		abortWithError(c, http.StatusBadRequest, "room is required")
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultPageSize)))
+	if err != nil || limit < 1 || limit > maxPageSize {
+		abortWithError(c, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxPageSize))
+		return
+	}
+	if !isMember(roomID, userID) {
+		abortWithError(c, http.StatusForbidden, "Not a member of this room")
+		return
+	}
+
+	query := state.GetConnection().Where("chat_room_id = ?", roomID)
+	forward := c.Query("after") != ""
+	if forward {
+		cursor, err := parseCursor(c.Query("after"))
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		query = query.Where("seq > ?", int64(cursor)).Order("seq ASC")
+	} else {
+		if before := c.Query("before"); before != "" {
+			cursor, err := parseCursor(before)
+			if err != nil {
+				abortWithError(c, http.StatusBadRequest, err.Error())
+				return
+			}
+			query = query.Where("seq < ?", int64(cursor))
+		}
+		query = query.Order("seq DESC")
+	}
+
+	var messages []entity.Message
+	if err := query.Limit(limit + 1).Find(&messages).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Failed to load messages")
+		return
+	}
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+	if !forward {
+		slices.Reverse(messages)
+	}
+
+	items := make([]protocol.Message, 0, len(messages))
+	for _, message := range messages {
+		items = append(items, toProtocolMessage(message))
+	}
+	page := gin.H{
+		"messages": items,
+		"has_more": hasMore,
+	}
+	if len(messages) > 0 {
+		first, last := messages[0], messages[len(messages)-1]
+		page["before"] = messageCursor(first.Seq).String()
+		page["after"] = messageCursor(last.Seq).String()
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "Success",
+		"data":   page,
+	})
+}
+
+// storeMessage persists a new message posted to the room by the author. The
+// message takes the next sequence number of the room, the room row lock
+// serializes concurrent sends and a rollback gives the number back, so the
+// sequence has no gaps. When the author already stored a message with the
+// client message id within the dedup window, that message is returned instead
+// and stored is false; an older one gives the id up to the new message.
+func storeMessage(roomID string, authorID string, text string, clientMsgID string) (*entity.Message, bool, error) {
+	// A second attempt follows the release of an id older than the window
+	for attempt := 0; ; attempt++ {
+		message, err := insertMessage(roomID, authorID, text, clientMsgID)
+		if !isDuplicateSend(err) {
+			return message, err == nil, err
+		}
+
+		existing, err := findSentMessage(authorID, clientMsgID, time.Time{})
+		if err == nil && existing == nil {
+			err = errMessageNotFound
+		}
+		if err != nil || attempt > 0 {
+			return existing, false, err
+		}
+		released, err := releaseClientMsgID(existing)
+		if err != nil || !released {
+			return existing, false, err
+		}
+	}
+}
+
+func insertMessage(roomID string, authorID string, text string, clientMsgID string) (*entity.Message, error) {
+	message := entity.Message{
+		ID:          uuid.New().String(),
+		ChatRoomID:  roomID,
+		AuthorID:    authorID,
+		ClientMsgID: clientMsgID,
+		Text:        text,
+		SeenBy:      entity.Receipts{},
+		ReceivedBy:  entity.Receipts{},
+		SentAt:      time.Now(),
+	}
+	err := state.GetConnection().Transaction(func(tx *gorm.DB) error {
+		result := tx.Raw("UPDATE chat_rooms SET last_seq = last_seq + 1 WHERE id = ? RETURNING last_seq", roomID).Scan(&message.Seq)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errRoomNotFound
+		}
+		return state.Create[entity.Message](tx, &message)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+func toProtocolMessage(message entity.Message) protocol.Message {
+	item := protocol.Message{
+		ID:          message.ID,
+		Room:        message.ChatRoomID,
+		Seq:         message.Seq,
+		AuthorID:    message.AuthorID,
+		ClientMsgID: message.ClientMsgID,
+		Text:        message.Text,
+		SentAt:      message.SentAt,
+		EditedAt:    message.EditedAt,
+	}
+	if message.DeletedAt != nil {
+		item.Text = ""
+		item.Deleted = true
+	}
+	return item
+}
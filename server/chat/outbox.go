@@ -0,0 +1,165 @@
+package chat
+
+import (
+	"main/chat/protocol"
+	"main/lib"
+	"sync"
+	"time"
+)
+
+// Policies applied when the outbox of a client is full.
+const (
+	// OutboxShed makes room by coalescing receipts and dropping the oldest
+	// ephemeral event, and only disconnects when neither frees a slot.
+	OutboxShed = "shed"
+	// OutboxDisconnect disconnects the client as soon as its outbox is full.
+	OutboxDisconnect = "disconnect"
+)
+
+// Reasons recorded by lib.RecordDroppedEvents.
+const (
+	droppedEphemeral    = "ephemeral"
+	droppedCoalesced    = "receipt_coalesced"
+	droppedDisconnected = "disconnected"
+)
+
+// outbox is the bounded queue of events waiting to be written to a client.
+type outbox struct {
+	mu     sync.Mutex
+	events []protocol.Event
+	limit  int
+	policy string
+	// ready and space signal the writer and waiting producers
+	ready chan struct{}
+	space chan struct{}
+}
+
+func newOutbox(limit int, policy string) *outbox {
+	return &outbox{
+		events: make([]protocol.Event, 0, limit),
+		limit:  limit,
+		policy: policy,
+		ready:  make(chan struct{}, 1),
+		space:  make(chan struct{}, 1),
+	}
+}
+
+// push queues the event without blocking, applying the policy when the outbox
+// is full. It returns false when the client has to be disconnected.
+func (o *outbox) push(event protocol.Event) bool {
+	o.mu.Lock()
+	if len(o.events) < o.limit {
+		o.events = append(o.events, event)
+	} else if !o.shed(event) {
+		lost := len(o.events) + 1
+		o.mu.Unlock()
+		lib.RecordDroppedEvents(droppedDisconnected, lost)
+		return false
+	}
+	o.mu.Unlock()
+
+	signal(o.ready)
+	return true
+}
+
+// pushWait queues the event, waiting up to timeout for a free slot instead of
+// applying the policy.
+func (o *outbox) pushWait(event protocol.Event, done <-chan struct{}, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		o.mu.Lock()
+		if len(o.events) < o.limit {
+			o.events = append(o.events, event)
+			o.mu.Unlock()
+			signal(o.ready)
+			return true
+		}
+		o.mu.Unlock()
+
+		select {
+		case <-o.space:
+		case <-done:
+			return false
+		case <-timer.C:
+			return false
+		}
+	}
+}
+
+// pop takes the oldest event, if any.
+func (o *outbox) pop() (protocol.Event, bool) {
+	o.mu.Lock()
+	if len(o.events) == 0 {
+		o.mu.Unlock()
+		return protocol.Event{}, false
+	}
+	event := o.events[0]
+	o.events[0] = protocol.Event{}
+	o.events = o.events[1:]
+	o.mu.Unlock()
+
+	signal(o.space)
+	return event, true
+}
+
+func (o *outbox) len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.events)
+}
+
+// shed expects o.mu to be held and tries to fit event in the full outbox.
+func (o *outbox) shed(event protocol.Event) bool {
+	if o.policy != OutboxShed {
+		return false
+	}
+
+	// A receipt supersedes a queued one for the same message and user,
+	// unless that one already reports the message as read
+	if incoming, ok := event.Payload.(protocol.ReceiptPayload); ok && event.Type == protocol.EventReceipt {
+		for i, queued := range o.events {
+			receipt, ok := queued.Payload.(protocol.ReceiptPayload)
+			if !ok || queued.Type != protocol.EventReceipt || receipt.MessageID != incoming.MessageID || receipt.UserID != incoming.UserID {
+				continue
+			}
+			if receipt.Status != protocol.AckRead {
+				o.events[i] = event
+			}
+			lib.RecordDroppedEvents(droppedCoalesced, 1)
+			return true
+		}
+	}
+
+	for i, queued := range o.events {
+		if isEphemeral(queued) {
+			o.events = append(o.events[:i], o.events[i+1:]...)
+			o.events = append(o.events, event)
+			lib.RecordDroppedEvents(droppedEphemeral, 1)
+			return true
+		}
+	}
+	if isEphemeral(event) {
+		lib.RecordDroppedEvents(droppedEphemeral, 1)
+		return true
+	}
+	return false
+}
+
+// isEphemeral tells whether losing the event only leaves a stale indicator on
+// the client, rather than missing data.
+func isEphemeral(event protocol.Event) bool {
+	switch event.Type {
+	case protocol.EventTypingStart, protocol.EventTypingStop, protocol.EventPresence:
+		return true
+	}
+	return false
+}
+
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
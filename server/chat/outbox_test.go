@@ -0,0 +1,51 @@
+package chat
+
+import (
+	"main/chat/protocol"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutbox(t *testing.T) {
+	message := protocol.NewEvent(protocol.EventMessageNew, "general", nil)
+	typingStart := protocol.NewEvent(protocol.EventTypingStart, "general", nil)
+	receipt := func(status string) protocol.Event {
+		return protocol.NewEvent(protocol.EventReceipt, "general", protocol.ReceiptPayload{MessageID: "m-1", UserID: "bob", Status: status})
+	}
+
+	t.Run("Shed drops the oldest ephemeral event", func(t *testing.T) {
+		o := newOutbox(2, OutboxShed)
+		o.push(typingStart)
+		o.push(message)
+
+		assert.True(t, o.push(message))
+		assert.Equal(t, 2, o.len())
+		first, _ := o.pop()
+		assert.Equal(t, protocol.EventMessageNew, first.Type)
+
+		assert.True(t, o.push(typingStart))
+		assert.Equal(t, 2, o.len())
+	})
+
+	t.Run("Shed coalesces receipts", func(t *testing.T) {
+		o := newOutbox(2, OutboxShed)
+		o.push(receipt(protocol.AckDelivered))
+		o.push(message)
+
+		assert.True(t, o.push(receipt(protocol.AckRead)))
+		assert.True(t, o.push(receipt(protocol.AckDelivered)))
+		first, _ := o.pop()
+		assert.Equal(t, protocol.AckRead, first.Payload.(protocol.ReceiptPayload).Status)
+	})
+
+	t.Run("Full outbox without anything to shed asks for a disconnect", func(t *testing.T) {
+		o := newOutbox(1, OutboxShed)
+		o.push(message)
+		assert.False(t, o.push(message))
+
+		o = newOutbox(1, OutboxDisconnect)
+		o.push(typingStart)
+		assert.False(t, o.push(typingStart))
+	})
+}
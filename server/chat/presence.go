@@ -0,0 +1,406 @@
+package chat
+
+import (
+	"main/chat/protocol"
+	"main/lib"
+	"main/state"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+const (
+	PresenceOnline  = "online"
+	PresenceAway    = "away"
+	PresenceOffline = "offline"
+)
+
+const (
+	// awayAfter is how long all devices of a user have to stay silent before
+	// the user is shown as away.
+	awayAfter          = 5 * time.Minute
+	presenceSweepEvery = 15 * time.Second
+	// presenceTTL is how long the report of a server counts without being
+	// repeated, the devices of a server that died being forgotten after it.
+	presenceTTL      = 3 * presenceSweepEvery
+	maxPresenceQuery = 100
+)
+
+type userPresence struct {
+	connections  int
+	status       string
+	lastActiveAt time.Time
+}
+
+// presenceReport is what a server tells the others about the devices of a
+// user connected to it, offline once the last one left. A report without
+// user only tells the server is alive.
+type presenceReport struct {
+	Node         string    `json:"node"`
+	UserID       string    `json:"user_id"`
+	Status       string    `json:"status"`
+	LastActiveAt time.Time `json:"last_active_at"`
+	// Rooms are told the new presence when the report changes it
+	Rooms []string `json:"rooms,omitempty"`
+}
+
+type nodePresence struct {
+	status       string
+	lastActiveAt time.Time
+	seenAt       time.Time
+}
+
+// presenceTracker derives the presence of users from their connections.
+// Each server tracks the connections of its own clients and reports changes,
+// and every sweep all of them, to the others through the hub. The presence
+// of a user is taken from the reports of every server, reports going through
+// the broker in the same order everywhere; the server whose report changes
+// it tells the rooms. Only connected users are tracked, everyone else is
+// offline.
+type presenceTracker struct {
+	mu   sync.Mutex
+	hub  *Hub
+	node string
+	// users are the users connected to this server
+	users map[string]*userPresence
+	// cluster holds the last report of every server, by user then server
+	cluster map[string]map[string]nodePresence
+	// nodes holds when every server last reported
+	nodes map[string]time.Time
+	// roomsOf returns the rooms of a user whose servers all went silent
+	roomsOf func(userID string) ([]string, error)
+	// publishMu keeps the reports of this server in order
+	publishMu sync.Mutex
+	startOnce sync.Once
+}
+
+var presence = hub.presence
+
+func newPresenceTracker(h *Hub, node string) *presenceTracker {
+	return &presenceTracker{
+		hub:     h,
+		node:    node,
+		users:   make(map[string]*userPresence),
+		cluster: make(map[string]map[string]nodePresence),
+		nodes:   make(map[string]time.Time),
+		roomsOf: userRoomIDs,
+	}
+}
+
+// Connected counts a new connection of the user, subscriptions of the client
+// have to be in place so its rooms learn the user came online.
+func (p *presenceTracker) Connected(userID string) {
+	p.startOnce.Do(func() {
+		go p.sweep()
+	})
+	p.publishMu.Lock()
+	defer p.publishMu.Unlock()
+
+	p.mu.Lock()
+	user, ok := p.users[userID]
+	if !ok {
+		user = &userPresence{}
+		p.users[userID] = user
+	}
+	user.connections++
+	user.lastActiveAt = time.Now()
+	changed := p.setStatus(user, PresenceOnline)
+	report := p.report(userID, user)
+	p.mu.Unlock()
+
+	if changed {
+		report.Rooms = p.hub.UserRooms(userID)
+		p.hub.PublishPresence([]presenceReport{report})
+	}
+}
+
+// Disconnected has to be called before the client leaves the hub, so its
+// rooms still learn the user went offline.
+func (p *presenceTracker) Disconnected(userID string) {
+	p.publishMu.Lock()
+	defer p.publishMu.Unlock()
+
+	p.mu.Lock()
+	user, ok := p.users[userID]
+	if !ok {
+		p.mu.Unlock()
+		return
+	}
+	user.connections--
+	if user.connections > 0 {
+		p.mu.Unlock()
+		return
+	}
+	delete(p.users, userID)
+	p.mu.Unlock()
+
+	report := presenceReport{Node: p.node, UserID: userID, Status: PresenceOffline, LastActiveAt: time.Now()}
+	report.Rooms = p.hub.UserRooms(userID)
+	p.hub.PublishPresence([]presenceReport{report})
+}
+
+// Touch records activity of the user, bringing an away user back online.
+func (p *presenceTracker) Touch(userID string) {
+	p.mu.Lock()
+	user, ok := p.users[userID]
+	if !ok || user.status == PresenceOnline {
+		if ok {
+			user.lastActiveAt = time.Now()
+		}
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+
+	p.publishMu.Lock()
+	defer p.publishMu.Unlock()
+
+	p.mu.Lock()
+	user, ok = p.users[userID]
+	if !ok {
+		p.mu.Unlock()
+		return
+	}
+	user.lastActiveAt = time.Now()
+	changed := p.setStatus(user, PresenceOnline)
+	report := p.report(userID, user)
+	p.mu.Unlock()
+
+	if changed {
+		report.Rooms = p.hub.UserRooms(userID)
+		p.hub.PublishPresence([]presenceReport{report})
+	}
+}
+
+// Get returns the status and last activity of the user.
+func (p *presenceTracker) Get(userID string) (string, *time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status, lastActiveAt := p.aggregate(userID)
+	if status == PresenceOffline {
+		return PresenceOffline, nil
+	}
+	return status, &lastActiveAt
+}
+
+// receive applies the reports of a server, this one included.
+func (p *presenceTracker) receive(reports []presenceReport) {
+	var announced []presenceReport
+	p.mu.Lock()
+	for _, report := range reports {
+		p.nodes[report.Node] = time.Now()
+		if report.UserID == "" {
+			continue
+		}
+		before, _ := p.aggregate(report.UserID)
+		nodes := p.cluster[report.UserID]
+		if report.Status == PresenceOffline {
+			delete(nodes, report.Node)
+			if len(nodes) == 0 {
+				delete(p.cluster, report.UserID)
+			}
+		} else {
+			if nodes == nil {
+				nodes = make(map[string]nodePresence)
+				p.cluster[report.UserID] = nodes
+			}
+			nodes[report.Node] = nodePresence{status: report.Status, lastActiveAt: report.LastActiveAt, seenAt: time.Now()}
+		}
+
+		after, lastActiveAt := p.aggregate(report.UserID)
+		if after == before || report.Node != p.node {
+			continue
+		}
+		if after == PresenceOffline {
+			lastActiveAt = report.LastActiveAt
+		}
+		announced = append(announced, presenceReport{UserID: report.UserID, Status: after, LastActiveAt: lastActiveAt, Rooms: report.Rooms})
+	}
+	p.mu.Unlock()
+
+	for _, report := range announced {
+		p.announce(report)
+	}
+}
+
+// sweep marks users away once they have been idle for awayAfter, repeats the
+// reports of this server and forgets those of servers gone silent.
+func (p *presenceTracker) sweep() {
+	for {
+		time.Sleep(presenceSweepEvery)
+		p.reportAll()
+		p.expire(time.Now())
+	}
+}
+
+func (p *presenceTracker) reportAll() {
+	p.publishMu.Lock()
+	defer p.publishMu.Unlock()
+
+	var reports []presenceReport
+	idle := make(map[string]struct{})
+	p.mu.Lock()
+	for userID, user := range p.users {
+		if time.Since(user.lastActiveAt) >= awayAfter && p.setStatus(user, PresenceAway) {
+			idle[userID] = struct{}{}
+		}
+		reports = append(reports, p.report(userID, user))
+	}
+	p.mu.Unlock()
+
+	if len(reports) == 0 {
+		reports = append(reports, presenceReport{Node: p.node})
+	}
+	for i, report := range reports {
+		if _, ok := idle[report.UserID]; ok {
+			reports[i].Rooms = p.hub.UserRooms(report.UserID)
+		}
+	}
+	p.hub.PublishPresence(reports)
+}
+
+// expire forgets the reports of servers silent for presenceTTL. Users left
+// without a server are announced offline by a single server, the live one
+// with the lowest id.
+func (p *presenceTracker) expire(now time.Time) {
+	var gone []presenceReport
+	p.mu.Lock()
+	for node, seenAt := range p.nodes {
+		if node != p.node && now.Sub(seenAt) > presenceTTL {
+			delete(p.nodes, node)
+		}
+	}
+	for userID, nodes := range p.cluster {
+		var lastActiveAt time.Time
+		for node, reported := range nodes {
+			if now.Sub(reported.seenAt) > presenceTTL {
+				delete(nodes, node)
+				if reported.lastActiveAt.After(lastActiveAt) {
+					lastActiveAt = reported.lastActiveAt
+				}
+			}
+		}
+		if len(nodes) == 0 {
+			delete(p.cluster, userID)
+			gone = append(gone, presenceReport{UserID: userID, Status: PresenceOffline, LastActiveAt: lastActiveAt})
+		}
+	}
+	announces := p.isLeader()
+	p.mu.Unlock()
+
+	if !announces {
+		return
+	}
+	for _, report := range gone {
+		rooms, err := p.roomsOf(report.UserID)
+		if err != nil {
+			lib.GetLogger().Warn("failed to load the rooms of a user gone offline", zap.String("userID", report.UserID), zap.Error(err))
+			continue
+		}
+		report.Rooms = rooms
+		p.announce(report)
+	}
+}
+
+// isLeader expects p.mu to be held and tells whether this server has the
+// lowest id of the live ones.
+func (p *presenceTracker) isLeader() bool {
+	for node := range p.nodes {
+		if node < p.node {
+			return false
+		}
+	}
+	return true
+}
+
+// report expects p.mu to be held.
+func (p *presenceTracker) report(userID string, user *userPresence) presenceReport {
+	return presenceReport{Node: p.node, UserID: userID, Status: user.status, LastActiveAt: user.lastActiveAt}
+}
+
+// aggregate expects p.mu to be held. The user is online when online on any
+// server and away when away on all of them.
+func (p *presenceTracker) aggregate(userID string) (string, time.Time) {
+	status := PresenceOffline
+	var lastActiveAt time.Time
+	for _, reported := range p.cluster[userID] {
+		if reported.status == PresenceOnline || status == PresenceOffline {
+			status = reported.status
+		}
+		if reported.lastActiveAt.After(lastActiveAt) {
+			lastActiveAt = reported.lastActiveAt
+		}
+	}
+	return status, lastActiveAt
+}
+
+// setStatus expects p.mu to be held and reports whether the status changed.
+func (p *presenceTracker) setStatus(user *userPresence, status string) bool {
+	if user.status == status {
+		return false
+	}
+	user.status = status
+	return true
+}
+
+// announce tells the rooms of the report about the new status of the user.
+func (p *presenceTracker) announce(report presenceReport) {
+	payload := protocol.PresencePayload{UserID: report.UserID, Status: report.Status, LastActiveAt: &report.LastActiveAt}
+	for _, room := range report.Rooms {
+		p.hub.Broadcast(room, protocol.NewEvent(protocol.EventPresence, room, payload))
+	}
+}
+
+// PresenceHandler returns the presence of the users listed in the
+// comma separated user_ids query param. Users sharing no room with the caller
+// are reported offline.
+func PresenceHandler(c *gin.Context) {
+	callerID, ok := requestUserID(c)
+	if !ok {
+		return
+	}
+
+	userIDs := strings.Split(c.Query("user_ids"), ",")
+	if c.Query("user_ids") == "" || len(userIDs) > maxPresenceQuery {
+		abortWithError(c, http.StatusBadRequest, "user_ids must list between 1 and 100 user ids")
+		return
+	}
+
+	valid := make([]string, 0, len(userIDs))
+	for _, userID := range userIDs {
+		if uuid.Validate(userID) == nil {
+			valid = append(valid, userID)
+		}
+	}
+	visible := map[string]struct{}{callerID: {}}
+	if len(valid) > 0 {
+		shared, err := sharingRoom(state.GetConnection(), callerID, valid)
+		if err != nil {
+			abortWithError(c, http.StatusInternalServerError, "Failed to load presence")
+			return
+		}
+		for userID := range shared {
+			visible[userID] = struct{}{}
+		}
+	}
+
+	statuses := make(map[string]protocol.PresencePayload, len(userIDs))
+	for _, userID := range userIDs {
+		status, lastActiveAt := PresenceOffline, (*time.Time)(nil)
+		if _, ok := visible[userID]; ok {
+			status, lastActiveAt = presence.Get(userID)
+		}
+		statuses[userID] = protocol.PresencePayload{UserID: userID, Status: status, LastActiveAt: lastActiveAt}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "Success",
+		"data":   statuses,
+	})
+}
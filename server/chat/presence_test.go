@@ -0,0 +1,91 @@
+package chat
+
+import (
+	"main/chat/protocol"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPresenceTracker(t *testing.T) {
+	report := func(node string, status string) presenceReport {
+		return presenceReport{Node: node, UserID: "bob", Status: status, LastActiveAt: time.Now()}
+	}
+
+	t.Run("User stays online while connected to another server", func(t *testing.T) {
+		p := newPresenceTracker(NewHub(), "a")
+		p.receive([]presenceReport{report("a", PresenceOnline), report("b", PresenceOnline)})
+
+		p.receive([]presenceReport{report("a", PresenceOffline)})
+		status, _ := p.Get("bob")
+		assert.Equal(t, PresenceOnline, status)
+
+		p.receive([]presenceReport{report("b", PresenceOffline)})
+		status, lastActiveAt := p.Get("bob")
+		assert.Equal(t, PresenceOffline, status)
+		assert.Nil(t, lastActiveAt)
+	})
+
+	t.Run("User is away once idle on every server", func(t *testing.T) {
+		p := newPresenceTracker(NewHub(), "a")
+		p.receive([]presenceReport{report("a", PresenceAway), report("b", PresenceOnline)})
+
+		status, _ := p.Get("bob")
+		assert.Equal(t, PresenceOnline, status)
+
+		p.receive([]presenceReport{report("b", PresenceAway)})
+		status, _ = p.Get("bob")
+		assert.Equal(t, PresenceAway, status)
+	})
+
+	t.Run("Reports of a silent server expire", func(t *testing.T) {
+		h := NewHub()
+		p := newPresenceTracker(h, "a")
+		p.roomsOf = func(string) ([]string, error) { return []string{"general"}, nil }
+		member := newClient("alice", nil, getSocketConfig())
+		h.Subscribe("general", member)
+		p.receive([]presenceReport{report("b", PresenceOnline)})
+
+		p.expire(time.Now().Add(presenceTTL / 2))
+		status, _ := p.Get("bob")
+		assert.Equal(t, PresenceOnline, status)
+		assert.Equal(t, 0, member.outbox.len())
+
+		p.expire(time.Now().Add(presenceTTL + time.Second))
+		status, _ = p.Get("bob")
+		assert.Equal(t, PresenceOffline, status)
+		event, _ := member.outbox.pop()
+		assert.Equal(t, protocol.EventPresence, event.Type)
+		assert.Equal(t, PresenceOffline, event.Payload.(protocol.PresencePayload).Status)
+	})
+
+	t.Run("Only the live server with the lowest id announces expiries", func(t *testing.T) {
+		h := NewHub()
+		p := newPresenceTracker(h, "c")
+		p.roomsOf = func(string) ([]string, error) { return []string{"general"}, nil }
+		member := newClient("alice", nil, getSocketConfig())
+		h.Subscribe("general", member)
+		p.receive([]presenceReport{{Node: "a"}, report("b", PresenceOnline)})
+		p.nodes["a"] = time.Now().Add(presenceTTL)
+
+		p.expire(time.Now().Add(presenceTTL + time.Second))
+		status, _ := p.Get("bob")
+		assert.Equal(t, PresenceOffline, status)
+		assert.Equal(t, 0, member.outbox.len())
+	})
+
+	t.Run("Connections are reported through the hub", func(t *testing.T) {
+		p := NewHub().presence
+
+		p.Connected("bob")
+		p.Connected("bob")
+		p.Disconnected("bob")
+		status, _ := p.Get("bob")
+		assert.Equal(t, PresenceOnline, status)
+
+		p.Disconnected("bob")
+		status, _ = p.Get("bob")
+		assert.Equal(t, PresenceOffline, status)
+	})
+}
@@ -0,0 +1,85 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec encodes the frames of a connection. Both codecs share the json tags
+// of the protocol structs, so fields are named the same in either format.
+type Codec struct {
+	// Subprotocol is the Sec-WebSocket-Protocol value selecting the codec.
+	Subprotocol string
+	// Binary tells whether frames are binary rather than text messages.
+	Binary bool
+
+	marshal   func(any) ([]byte, error)
+	unmarshal func([]byte, any) error
+}
+
+var (
+	JSON        = &Codec{Subprotocol: "p-chat.v1.json", marshal: json.Marshal, unmarshal: json.Unmarshal}
+	MessagePack = &Codec{Subprotocol: "p-chat.v1.msgpack", Binary: true, marshal: marshalMsgpack, unmarshal: unmarshalMsgpack}
+)
+
+// CodecFor returns the codec selected by the first of the subprotocols offered
+// by the client naming one. When none does, JSON is returned and ok is false.
+func CodecFor(subprotocols []string) (codec *Codec, ok bool) {
+	for _, subprotocol := range subprotocols {
+		for _, codec := range []*Codec{JSON, MessagePack} {
+			if subprotocol == codec.Subprotocol {
+				return codec, true
+			}
+		}
+	}
+	return JSON, false
+}
+
+func (c *Codec) Encode(event Event) ([]byte, error) {
+	return c.marshal(event)
+}
+
+// Decode parses a client frame and validates both the envelope and its
+// payload. The returned error is ready to be sent back as an error frame.
+func (c *Codec) Decode(data []byte) (Envelope, Command, *Error) {
+	return decode(data, c.unmarshal)
+}
+
+// RawPayload keeps the payload of a frame encoded until the frame type tells
+// which Command it holds.
+type RawPayload []byte
+
+func (p RawPayload) MarshalJSON() ([]byte, error) {
+	if len(p) == 0 {
+		return []byte("null"), nil
+	}
+	return p, nil
+}
+
+func (p *RawPayload) UnmarshalJSON(data []byte) error {
+	*p = append((*p)[:0], data...)
+	return nil
+}
+
+func (p *RawPayload) DecodeMsgpack(dec *msgpack.Decoder) error {
+	raw, err := dec.DecodeRaw()
+	*p = RawPayload(raw)
+	return err
+}
+
+func marshalMsgpack(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	err := enc.Encode(v)
+	return buf.Bytes(), err
+}
+
+func unmarshalMsgpack(data []byte, v any) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
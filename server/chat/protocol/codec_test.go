@@ -0,0 +1,63 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestCodec(t *testing.T) {
+	t.Run("MessagePack frames decode like JSON ones", func(t *testing.T) {
+		frame, _ := msgpack.Marshal(map[string]any{
+			"v":       Version,
+			"type":    CommandSend,
+			"id":      "c-1",
+			"room":    "general",
+			"payload": map[string]any{"text": "hello", "client_msg_id": "m-1"},
+		})
+
+		envelope, command, err := MessagePack.Decode(frame)
+		assert.Nil(t, err)
+		assert.Equal(t, "general", envelope.Room)
+		assert.Equal(t, &SendPayload{Text: "hello", ClientMsgID: "m-1"}, command)
+	})
+
+	t.Run("MessagePack events keep the JSON field names", func(t *testing.T) {
+		data, err := MessagePack.Encode(NewEvent(EventAck, "general", AckEventPayload{Ref: "c-1", Seq: 7}))
+		assert.NoError(t, err)
+
+		var decoded map[string]any
+		assert.NoError(t, msgpack.Unmarshal(data, &decoded))
+		assert.Equal(t, EventAck, decoded["type"])
+		assert.Equal(t, map[string]any{"ref": "c-1", "seq": int8(7)}, decoded["payload"])
+	})
+
+	t.Run("Codec is picked from the offered subprotocols", func(t *testing.T) {
+		codec, ok := CodecFor([]string{"access_token", "token", MessagePack.Subprotocol})
+		assert.True(t, ok)
+		assert.Same(t, MessagePack, codec)
+
+		codec, ok = CodecFor([]string{"access_token", "token"})
+		assert.False(t, ok)
+		assert.Same(t, JSON, codec)
+	})
+
+	t.Run("Encoded events decode back to their payload struct", func(t *testing.T) {
+		event := NewEvent(EventReceipt, "general", ReceiptPayload{MessageID: "m-1", UserID: "bob", Status: AckRead})
+		data, err := JSON.Encode(event)
+		assert.NoError(t, err)
+
+		decoded, err := DecodeEvent(data)
+		assert.NoError(t, err)
+		assert.Equal(t, event.Type, decoded.Type)
+		assert.Equal(t, event.Ts, decoded.Ts)
+		assert.IsType(t, ReceiptPayload{}, decoded.Payload)
+		assert.Equal(t, "bob", decoded.Payload.(ReceiptPayload).UserID)
+
+		data, _ = JSON.Encode(NewError(ErrForbidden, "no").Event("general"))
+		decoded, err = DecodeEvent(data)
+		assert.NoError(t, err)
+		assert.Equal(t, ErrForbidden, decoded.Payload.(*Error).Code)
+	})
+}
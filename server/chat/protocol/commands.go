@@ -0,0 +1,182 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Client command types.
+const (
+	CommandSend   = "send"
+	CommandJoin   = "join"
+	CommandLeave  = "leave"
+	CommandTyping = "typing"
+	CommandAck    = "ack"
+	CommandEdit   = "edit"
+	CommandDelete = "delete"
+	CommandResume = "resume"
+)
+
+const (
+	TypingStart = "start"
+	TypingStop  = "stop"
+)
+
+const (
+	AckDelivered = "delivered"
+	AckRead      = "read"
+)
+
+// MaxTextLength is the longest message text accepted, in characters.
+const MaxTextLength = 4000
+
+// MaxResumeRooms is how many rooms a single resume frame may list.
+const MaxResumeRooms = 200
+
+// Command is the typed payload of a client frame.
+type Command interface {
+	Validate() error
+}
+
+// SendPayload posts a new message to the envelope room. ClientMsgID is an id
+// the client generates once per message and repeats when it retries the send.
+type SendPayload struct {
+	Text        string `json:"text"`
+	ClientMsgID string `json:"client_msg_id"`
+}
+
+// JoinPayload subscribes the connection to live events of the envelope room.
+type JoinPayload struct{}
+
+// LeavePayload stops live events of the envelope room on the connection.
+type LeavePayload struct{}
+
+// TypingPayload tells the room the user started or stopped typing.
+type TypingPayload struct {
+	State string `json:"state"`
+}
+
+// AckPayload acknowledges a message was delivered to or read by the user.
+type AckPayload struct {
+	MessageID string `json:"message_id"`
+	Status    string `json:"status"`
+}
+
+// EditPayload replaces the text of a message.
+type EditPayload struct {
+	MessageID string `json:"message_id"`
+	Text      string `json:"text"`
+}
+
+// DeletePayload removes a message.
+type DeletePayload struct {
+	MessageID string `json:"message_id"`
+}
+
+// ResumePayload asks the server to replay what the connection missed in each
+// listed room. Unlike other commands it isn't bound to the envelope room.
+type ResumePayload struct {
+	Rooms map[string]ResumeCursor `json:"rooms"`
+}
+
+// ResumeCursor is the last message the client has seen in a room, given by
+// its sequence number or, failing that, its id. A cursor with neither, or
+// last_seq 0, replays the room from its first message.
+type ResumeCursor struct {
+	LastSeq       int64  `json:"last_seq,omitempty"`
+	LastMessageID string `json:"last_message_id,omitempty"`
+}
+
+func newCommand(commandType string) Command {
+	switch commandType {
+	case CommandSend:
+		return &SendPayload{}
+	case CommandJoin:
+		return &JoinPayload{}
+	case CommandLeave:
+		return &LeavePayload{}
+	case CommandTyping:
+		return &TypingPayload{}
+	case CommandAck:
+		return &AckPayload{}
+	case CommandEdit:
+		return &EditPayload{}
+	case CommandDelete:
+		return &DeletePayload{}
+	case CommandResume:
+		return &ResumePayload{}
+	}
+	return nil
+}
+
+func (p *SendPayload) Validate() error {
+	if err := validateText(p.Text); err != nil {
+		return err
+	}
+	if p.ClientMsgID == "" || len(p.ClientMsgID) > maxIDLength {
+		return fmt.Errorf("client_msg_id is required and can't exceed %d characters", maxIDLength)
+	}
+	return nil
+}
+
+func (p *JoinPayload) Validate() error { return nil }
+
+func (p *LeavePayload) Validate() error { return nil }
+
+func (p *TypingPayload) Validate() error {
+	if p.State != TypingStart && p.State != TypingStop {
+		return fmt.Errorf("state must be %q or %q", TypingStart, TypingStop)
+	}
+	return nil
+}
+
+func (p *AckPayload) Validate() error {
+	if p.Status != AckDelivered && p.Status != AckRead {
+		return fmt.Errorf("status must be %q or %q", AckDelivered, AckRead)
+	}
+	return validateMessageID(p.MessageID)
+}
+
+func (p *EditPayload) Validate() error {
+	if err := validateMessageID(p.MessageID); err != nil {
+		return err
+	}
+	return validateText(p.Text)
+}
+
+func (p *DeletePayload) Validate() error {
+	return validateMessageID(p.MessageID)
+}
+
+func (p *ResumePayload) Validate() error {
+	if len(p.Rooms) == 0 || len(p.Rooms) > MaxResumeRooms {
+		return fmt.Errorf("rooms must list between 1 and %d rooms", MaxResumeRooms)
+	}
+	for room, cursor := range p.Rooms {
+		if room == "" || len(room) > maxRoomLength {
+			return fmt.Errorf("room ids can't be empty or exceed %d characters", maxRoomLength)
+		}
+		if cursor.LastSeq < 0 || len(cursor.LastMessageID) > maxIDLength {
+			return fmt.Errorf("invalid last_seq or last_message_id for room %q", room)
+		}
+	}
+	return nil
+}
+
+func validateText(text string) error {
+	if strings.TrimSpace(text) == "" {
+		return errors.New("text can't be empty")
+	}
+	if len([]rune(text)) > MaxTextLength {
+		return fmt.Errorf("text can't exceed %d characters", MaxTextLength)
+	}
+	return nil
+}
+
+func validateMessageID(id string) error {
+	if id == "" || len(id) > maxIDLength {
+		return errors.New("message_id is required")
+	}
+	return nil
+}
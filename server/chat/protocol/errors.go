@@ -0,0 +1,45 @@
+package protocol
+
+import "fmt"
+
+// Machine readable error codes carried by error frames.
+const (
+	ErrBadFrame           = "bad_frame"
+	ErrUnsupportedVersion = "unsupported_version"
+	ErrUnknownType        = "unknown_type"
+	ErrInvalidPayload     = "invalid_payload"
+	ErrForbidden          = "forbidden"
+	ErrNotFound           = "not_found"
+	ErrRateLimited        = "rate_limited"
+	ErrInternal           = "internal"
+)
+
+// Error is the payload of error events. Ref holds the id of the client frame
+// that caused it, if it could be read. RetryAfterMs tells rate limited clients
+// when to retry.
+type Error struct {
+	Code         string `json:"code"`
+	Message      string `json:"message"`
+	Ref          string `json:"ref,omitempty"`
+	RetryAfterMs int64  `json:"retry_after_ms,omitempty"`
+}
+
+func NewError(code string, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// WithRef returns a copy of the error pointing at the client frame ref.
+func (e *Error) WithRef(ref string) *Error {
+	copied := *e
+	copied.Ref = ref
+	return &copied
+}
+
+// Event wraps the error into an error frame.
+func (e *Error) Event(room string) Event {
+	return NewEvent(EventError, room, e)
+}
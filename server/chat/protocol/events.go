@@ -0,0 +1,181 @@
+package protocol
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Server event types.
+const (
+	EventMessageNew     = "message.new"
+	EventMessageEdited  = "message.edited"
+	EventMessageDeleted = "message.deleted"
+	EventMemberJoined   = "member.joined"
+	EventMemberLeft     = "member.left"
+	EventRoomUpdated    = "room.updated"
+	EventRoomDeleted    = "room.deleted"
+	EventTypingStart    = "typing.start"
+	EventTypingStop     = "typing.stop"
+	EventReceipt        = "receipt"
+	EventPresence       = "presence"
+	EventResumed        = "resumed"
+	EventAck            = "ack"
+	EventConnected      = "connected"
+	EventGoingAway      = "server.going_away"
+	EventError          = "error"
+)
+
+// Message is the payload of message.new and message.edited events, and the
+// item returned by the history endpoint. Deleted messages are tombstones
+// without text. Seq numbers the messages of a room from 1 without gaps.
+type Message struct {
+	ID       string `json:"id"`
+	Room     string `json:"room"`
+	Seq      int64  `json:"seq"`
+	AuthorID string `json:"author_id"`
+	// ClientMsgID lets the author's devices match the message to their send
+	ClientMsgID string     `json:"client_msg_id,omitempty"`
+	Text        string     `json:"text"`
+	SentAt      time.Time  `json:"sent_at"`
+	EditedAt    *time.Time `json:"edited_at,omitempty"`
+	Deleted     bool       `json:"deleted,omitempty"`
+}
+
+// Room is the payload of room.updated events, and the item returned by the
+// room endpoints.
+type Room struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Kind    string   `json:"kind"`
+	Members []Member `json:"members"`
+}
+
+// Member is a user belonging to a room.
+type Member struct {
+	UserID   string    `json:"user_id"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// MessageDeletedPayload is the payload of message.deleted events.
+type MessageDeletedPayload struct {
+	MessageID string `json:"message_id"`
+}
+
+// MemberPayload is the payload of member.joined and member.left events.
+type MemberPayload struct {
+	UserID string `json:"user_id"`
+}
+
+// TypingEventPayload is the payload of typing.start and typing.stop events.
+type TypingEventPayload struct {
+	UserID string `json:"user_id"`
+}
+
+// PresencePayload is the payload of presence events, status being online,
+// away or offline.
+type PresencePayload struct {
+	UserID       string     `json:"user_id"`
+	Status       string     `json:"status"`
+	LastActiveAt *time.Time `json:"last_active_at,omitempty"`
+}
+
+// ReceiptPayload is the payload of receipt events sent to message authors.
+type ReceiptPayload struct {
+	MessageID string    `json:"message_id"`
+	UserID    string    `json:"user_id"`
+	Status    string    `json:"status"`
+	At        time.Time `json:"at"`
+}
+
+// AckEventPayload is the payload of ack events confirming the client frame Ref
+// was processed. Commands storing or changing a message carry its id and seq.
+type AckEventPayload struct {
+	Ref       string `json:"ref"`
+	MessageID string `json:"message_id,omitempty"`
+	Seq       int64  `json:"seq,omitempty"`
+}
+
+// ConnectedPayload is the payload of the connected event opening an SSE or
+// long-poll connection. Commands are posted with its ConnectionID.
+type ConnectedPayload struct {
+	ConnectionID string `json:"connection_id"`
+}
+
+// GoingAwayPayload is the payload of the server.going_away event sent before a
+// server shuts down. Clients reconnect with resume=1 after ReconnectAfterMs,
+// which is spread out so they don't all come back at once.
+type GoingAwayPayload struct {
+	ReconnectAfterMs int64 `json:"reconnect_after_ms"`
+}
+
+// ResumedPayload is the payload of the resumed event closing a replay. Skipped
+// lists the rooms that weren't replayed, because the connection isn't
+// subscribed to them or the cursor message doesn't belong to them.
+type ResumedPayload struct {
+	Rooms   map[string]ResumedRoom `json:"rooms"`
+	Skipped []string               `json:"skipped,omitempty"`
+}
+
+// ResumedRoom tells how many messages of a room were replayed. When HasMore is
+// set the replay was cut short and the rest has to be fetched from the history
+// endpoint, starting at the After cursor.
+type ResumedRoom struct {
+	Replayed int    `json:"replayed"`
+	HasMore  bool   `json:"has_more"`
+	After    string `json:"after,omitempty"`
+}
+
+// DecodeEvent parses a JSON event back into the payload struct of its type, so
+// events relayed between servers can be handled like locally built ones.
+func DecodeEvent(data []byte) (Event, error) {
+	var raw struct {
+		Event
+		Payload json.RawMessage `json:"payload,omitempty"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return Event{}, err
+	}
+	event := raw.Event
+	if len(raw.Payload) == 0 || string(raw.Payload) == "null" {
+		return event, nil
+	}
+
+	var err error
+	switch event.Type {
+	case EventMessageNew, EventMessageEdited:
+		event.Payload, err = decodePayload[Message](raw.Payload)
+	case EventMessageDeleted:
+		event.Payload, err = decodePayload[MessageDeletedPayload](raw.Payload)
+	case EventMemberJoined, EventMemberLeft:
+		event.Payload, err = decodePayload[MemberPayload](raw.Payload)
+	case EventRoomUpdated:
+		event.Payload, err = decodePayload[Room](raw.Payload)
+	case EventTypingStart, EventTypingStop:
+		event.Payload, err = decodePayload[TypingEventPayload](raw.Payload)
+	case EventReceipt:
+		event.Payload, err = decodePayload[ReceiptPayload](raw.Payload)
+	case EventPresence:
+		event.Payload, err = decodePayload[PresencePayload](raw.Payload)
+	case EventResumed:
+		event.Payload, err = decodePayload[ResumedPayload](raw.Payload)
+	case EventAck:
+		event.Payload, err = decodePayload[AckEventPayload](raw.Payload)
+	case EventConnected:
+		event.Payload, err = decodePayload[ConnectedPayload](raw.Payload)
+	case EventGoingAway:
+		event.Payload, err = decodePayload[GoingAwayPayload](raw.Payload)
+	case EventError:
+		event.Payload, err = decodePayload[*Error](raw.Payload)
+	default:
+		return event, fmt.Errorf("unknown event type %q", event.Type)
+	}
+	return event, err
+}
+
+func decodePayload[T any](data json.RawMessage) (T, error) {
+	var payload T
+	err := json.Unmarshal(data, &payload)
+	return payload, err
+}
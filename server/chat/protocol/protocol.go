@@ -0,0 +1,82 @@
+// Package protocol describes the frames exchanged over the chat WebSocket.
+//
+// Every frame is an Envelope, encoded as JSON or MessagePack depending on the
+// Codec the connection negotiated. Clients send commands (send, join, leave,
+// typing, ack, edit, delete, resume) and the server answers with events
+// (message.new, member.joined, error, ...). The payload shape depends on the
+// frame type and is described by the matching *Payload struct.
+package protocol
+
+import (
+	"time"
+)
+
+// Version is the protocol version clients have to put in every frame.
+const Version = 1
+
+const (
+	maxIDLength   = 64
+	maxRoomLength = 255
+)
+
+// Envelope is a frame received from a client.
+type Envelope struct {
+	V       int        `json:"v"`
+	Type    string     `json:"type"`
+	ID      string     `json:"id"`
+	Room    string     `json:"room,omitempty"`
+	Payload RawPayload `json:"payload,omitempty"`
+	// Ts is the client clock in unix milliseconds, informative only.
+	Ts int64 `json:"ts,omitempty"`
+}
+
+// Event is a frame sent by the server.
+type Event struct {
+	V       int    `json:"v"`
+	Type    string `json:"type"`
+	Room    string `json:"room,omitempty"`
+	Payload any    `json:"payload,omitempty"`
+	// Ts is the server clock in unix milliseconds.
+	Ts int64 `json:"ts"`
+}
+
+func NewEvent(eventType string, room string, payload any) Event {
+	return Event{V: Version, Type: eventType, Room: room, Payload: payload, Ts: time.Now().UnixMilli()}
+}
+
+// Decode parses a JSON client frame, see Codec.Decode.
+func Decode(data []byte) (Envelope, Command, *Error) {
+	return JSON.Decode(data)
+}
+
+func decode(data []byte, unmarshal func([]byte, any) error) (Envelope, Command, *Error) {
+	var env Envelope
+	if err := unmarshal(data, &env); err != nil {
+		return env, nil, NewError(ErrBadFrame, "frame is not a valid envelope")
+	}
+	if env.V != Version {
+		return env, nil, NewError(ErrUnsupportedVersion, "protocol version %d is not supported", env.V).WithRef(env.ID)
+	}
+	if env.ID == "" || len(env.ID) > maxIDLength {
+		return env, nil, NewError(ErrBadFrame, "id is required and can't exceed %d characters", maxIDLength)
+	}
+
+	command := newCommand(env.Type)
+	if command == nil {
+		return env, nil, NewError(ErrUnknownType, "unknown frame type %q", env.Type).WithRef(env.ID)
+	}
+	// resume names its rooms in the payload
+	if (env.Room == "" && env.Type != CommandResume) || len(env.Room) > maxRoomLength {
+		return env, nil, NewError(ErrBadFrame, "room is required and can't exceed %d characters", maxRoomLength).WithRef(env.ID)
+	}
+	if len(env.Payload) > 0 {
+		if err := unmarshal(env.Payload, command); err != nil {
+			return env, nil, NewError(ErrInvalidPayload, "payload doesn't match %q", env.Type).WithRef(env.ID)
+		}
+	}
+	if err := command.Validate(); err != nil {
+		return env, nil, NewError(ErrInvalidPayload, "%s", err.Error()).WithRef(env.ID)
+	}
+
+	return env, command, nil
+}
@@ -0,0 +1,67 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecode(t *testing.T) {
+	t.Run("Valid send", func(t *testing.T) {
+		frame := `{"v":1,"type":"send","id":"c-1","room":"general","payload":{"text":"hello","client_msg_id":"m-1"},"ts":1738528896000}`
+
+		envelope, command, err := Decode([]byte(frame))
+		assert.Nil(t, err)
+		assert.Equal(t, "general", envelope.Room)
+		assert.Equal(t, &SendPayload{Text: "hello", ClientMsgID: "m-1"}, command)
+	})
+
+	t.Run("Payload less join", func(t *testing.T) {
+		_, command, err := Decode([]byte(`{"v":1,"type":"join","id":"c-2","room":"general"}`))
+		assert.Nil(t, err)
+		assert.IsType(t, &JoinPayload{}, command)
+	})
+
+	t.Run("Resume names its rooms in the payload", func(t *testing.T) {
+		frame := `{"v":1,"type":"resume","id":"c-12","payload":{"rooms":{"general":{"last_message_id":"m-1"}}}}`
+
+		_, command, err := Decode([]byte(frame))
+		assert.Nil(t, err)
+		assert.Equal(t, &ResumePayload{Rooms: map[string]ResumeCursor{"general": {LastMessageID: "m-1"}}}, command)
+
+		_, command, err = Decode([]byte(`{"v":1,"type":"resume","id":"c-16","payload":{"rooms":{"dm":{"last_seq":0}}}}`))
+		assert.Nil(t, err)
+		assert.Equal(t, &ResumePayload{Rooms: map[string]ResumeCursor{"dm": {}}}, command)
+	})
+
+	t.Run("Rejected frames", func(t *testing.T) {
+		cases := map[string]string{
+			`not json`: ErrBadFrame,
+			`{"v":2,"type":"send","id":"c-3","room":"general"}`:                                         ErrUnsupportedVersion,
+			`{"v":1,"type":"send","room":"general","payload":{"text":"hello"}}`:                         ErrBadFrame,
+			`{"v":1,"type":"send","id":"c-4","payload":{"text":"hello"}}`:                               ErrBadFrame,
+			`{"v":1,"type":"shout","id":"c-5","room":"general"}`:                                        ErrUnknownType,
+			`{"v":1,"type":"send","id":"c-6","room":"general","payload":{"text":"  "}}`:                 ErrInvalidPayload,
+			`{"v":1,"type":"send","id":"c-7","room":"general","payload":{"text":1}}`:                    ErrInvalidPayload,
+			`{"v":1,"type":"send","id":"c-15","room":"general","payload":{"text":"hello"}}`:             ErrInvalidPayload,
+			`{"v":1,"type":"typing","id":"c-8","room":"general","payload":{"state":"maybe"}}`:           ErrInvalidPayload,
+			`{"v":1,"type":"ack","id":"c-9","room":"general","payload":{"message_id":"m","status":""}}`: ErrInvalidPayload,
+			`{"v":1,"type":"delete","id":"c-10","room":"general","payload":{}}`:                         ErrInvalidPayload,
+			`{"v":1,"type":"resume","id":"c-13","payload":{"rooms":{}}}`:                                ErrInvalidPayload,
+			`{"v":1,"type":"resume","id":"c-14","payload":{"rooms":{"general":{"last_seq":-1}}}}`:       ErrInvalidPayload,
+		}
+
+		for frame, code := range cases {
+			_, _, err := Decode([]byte(frame))
+			if assert.NotNil(t, err, frame) {
+				assert.Equal(t, code, err.Code, frame)
+			}
+		}
+	})
+
+	t.Run("Error keeps frame reference", func(t *testing.T) {
+		_, _, err := Decode([]byte(`{"v":1,"type":"shout","id":"c-11","room":"general"}`))
+		assert.Equal(t, "c-11", err.Ref)
+		assert.Equal(t, EventError, err.Event("general").Type)
+	})
+}
@@ -0,0 +1,35 @@
+package chat
+
+import (
+	"main/chat/protocol"
+	"main/lib"
+	"sync"
+)
+
+var (
+	limitersOnce sync.Once
+	// frameLimiter limits the frames of a connection, sendLimiter the
+	// messages a user sends across all their devices.
+	frameLimiter *lib.RateLimiter
+	sendLimiter  *lib.RateLimiter
+)
+
+// allowFrame returns a rate_limited error when the client has to slow down
+// before the command runs.
+func allowFrame(client *Client, envelope protocol.Envelope) *protocol.Error {
+	limitersOnce.Do(func() {
+		frameLimiter = lib.NewRateLimiterFromEnv("RATE_LIMIT_WS_FRAMES", 600, 60)
+		sendLimiter = lib.NewRateLimiterFromEnv("RATE_LIMIT_WS_SENDS", 60, 10)
+	})
+
+	allowed, retryAfter := frameLimiter.Allow(client.ID.String())
+	if allowed && envelope.Type == protocol.CommandSend {
+		allowed, retryAfter = sendLimiter.Allow(client.UserID)
+	}
+	if allowed {
+		return nil
+	}
+	err := protocol.NewError(protocol.ErrRateLimited, "rate limited, retry in %d ms", retryAfter.Milliseconds()).WithRef(envelope.ID)
+	err.RetryAfterMs = retryAfter.Milliseconds()
+	return err
+}
@@ -0,0 +1,255 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"main/chat/protocol"
+	"main/lib"
+	"main/state"
+	"main/state/entity"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"gorm.io/gorm"
+)
+
+const (
+	receiptFlushInterval = 500 * time.Millisecond
+	receiptBatchSize     = 500
+)
+
+type receipt struct {
+	MessageID string
+	RoomID    string
+	UserID    string
+	Status    string
+	At        time.Time
+}
+
+type receiptKey struct {
+	messageID string
+	userID    string
+}
+
+// receiptBatcher collects delivered and read acknowledgements and writes them
+// with a single UPDATE per flush instead of one per ack.
+type receiptBatcher struct {
+	mu        sync.Mutex
+	pending   map[receiptKey]receipt
+	flushNow  chan struct{}
+	startOnce sync.Once
+}
+
+var receipts = &receiptBatcher{
+	pending:  make(map[receiptKey]receipt),
+	flushNow: make(chan struct{}, 1),
+}
+
+// Add queues a receipt. A read receipt replaces a pending delivered one for
+// the same message and user, as reading implies delivery.
+func (b *receiptBatcher) Add(r receipt) {
+	b.startOnce.Do(func() {
+		go b.run()
+	})
+
+	b.mu.Lock()
+	b.queue(r)
+	full := len(b.pending) >= receiptBatchSize
+	b.mu.Unlock()
+
+	if full {
+		select {
+		case b.flushNow <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (b *receiptBatcher) run() {
+	ticker := time.NewTicker(receiptFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-b.flushNow:
+		}
+		if err := b.Flush(); err != nil {
+			lib.GetLogger().Error("failed to flush receipts", zap.Error(err))
+		}
+	}
+}
+
+// queue expects b.mu to be held.
+func (b *receiptBatcher) queue(r receipt) {
+	key := receiptKey{messageID: r.MessageID, userID: r.UserID}
+	if queued, ok := b.pending[key]; !ok || queued.Status == protocol.AckDelivered {
+		b.pending[key] = r
+	}
+}
+
+// Flush writes the pending receipts, receiptBatchSize messages per
+// statement, and notifies the authors of the receipts their messages didn't
+// have yet. Receipts left unwritten by a failure are queued again for the
+// next flush.
+func (b *receiptBatcher) Flush() error {
+	b.mu.Lock()
+	batch := b.pending
+	b.pending = make(map[receiptKey]receipt)
+	b.mu.Unlock()
+
+	byMessage := make(map[string][]receipt)
+	for _, r := range batch {
+		byMessage[r.MessageID] = append(byMessage[r.MessageID], r)
+	}
+	chunks := make([][]receipt, 0, len(byMessage)/receiptBatchSize+1)
+	var chunk []receipt
+	messages := 0
+	for _, rs := range byMessage {
+		if messages == receiptBatchSize {
+			chunks = append(chunks, chunk)
+			chunk, messages = nil, 0
+		}
+		chunk = append(chunk, rs...)
+		messages++
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+
+	for i, chunk := range chunks {
+		if err := writeReceipts(chunk); err != nil {
+			b.mu.Lock()
+			for _, rest := range chunks[i:] {
+				for _, r := range rest {
+					b.queue(r)
+				}
+			}
+			b.mu.Unlock()
+			return err
+		}
+	}
+	return nil
+}
+
+// writeReceipts stores the receipts with one UPDATE and notifies the authors.
+func writeReceipts(batch []receipt) error {
+	// Postgres can't update the same row twice in one statement, so the
+	// receipts are merged into one received/seen patch per message.
+	type patch struct {
+		roomID   string
+		received entity.Receipts
+		seen     entity.Receipts
+	}
+	patches := make(map[string]*patch)
+	for _, r := range batch {
+		p, ok := patches[r.MessageID]
+		if !ok {
+			p = &patch{roomID: r.RoomID, received: entity.Receipts{}, seen: entity.Receipts{}}
+			patches[r.MessageID] = p
+		}
+		p.received[r.UserID] = r.At
+		if r.Status == protocol.AckRead {
+			p.seen[r.UserID] = r.At
+		}
+	}
+
+	values := make([]string, 0, len(patches))
+	args := make([]any, 0, 4*len(patches))
+	for messageID, p := range patches {
+		received, _ := json.Marshal(p.received)
+		seen, _ := json.Marshal(p.seen)
+		values = append(values, "(?::uuid, ?, ?::jsonb, ?::jsonb)")
+		args = append(args, messageID, p.roomID, string(received), string(seen))
+	}
+
+	// Existing entries win the merge, so the first time a user received or
+	// saw a message is kept. The old row, joined as o, tells which receipts
+	// are new.
+	query := `UPDATE messages AS m
+		SET received_by = v.received || m.received_by, seen_by = v.seen || m.seen_by
+		FROM (VALUES ` + strings.Join(values, ", ") + `) AS v(id, room_id, received, seen), messages AS o
+		WHERE m.id = v.id AND m.chat_room_id = v.room_id AND m.deleted_at IS NULL AND o.id = m.id
+		RETURNING m.id, m.author_id, o.received_by, o.seen_by`
+	var updated []struct {
+		ID         string
+		AuthorID   string
+		ReceivedBy entity.Receipts
+		SeenBy     entity.Receipts
+	}
+	if err := state.GetConnection().Raw(query, args...).Scan(&updated).Error; err != nil {
+		return err
+	}
+
+	type previous struct {
+		authorID string
+		received entity.Receipts
+		seen     entity.Receipts
+	}
+	messages := make(map[string]previous, len(updated))
+	for _, message := range updated {
+		messages[message.ID] = previous{authorID: message.AuthorID, received: message.ReceivedBy, seen: message.SeenBy}
+	}
+	for _, r := range batch {
+		message, ok := messages[r.MessageID]
+		if !ok || message.authorID == r.UserID || hadReceipt(message.received, message.seen, r) {
+			continue
+		}
+		hub.SendToUser(message.authorID, protocol.NewEvent(protocol.EventReceipt, r.RoomID, protocol.ReceiptPayload{
+			MessageID: r.MessageID,
+			UserID:    r.UserID,
+			Status:    r.Status,
+			At:        r.At,
+		}))
+	}
+	return nil
+}
+
+// hadReceipt tells whether the message already had the receipt before the
+// update, in which case the author was notified of it then.
+func hadReceipt(received entity.Receipts, seen entity.Receipts, r receipt) bool {
+	receipts := received
+	if r.Status == protocol.AckRead {
+		receipts = seen
+	}
+	_, ok := receipts[r.UserID]
+	return ok
+}
+
+// ReceiptsHandler returns who received and who saw a message.
+func ReceiptsHandler(c *gin.Context) {
+	userID, ok := requestUserID(c)
+	if !ok {
+		return
+	}
+
+	messageID := c.Param("id")
+	if uuid.Validate(messageID) != nil {
+		abortWithError(c, http.StatusNotFound, "Message not found")
+		return
+	}
+	var message entity.Message
+	err := state.GetByID[entity.Message](state.GetConnection(), messageID, &message)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && !isMember(message.ChatRoomID, userID)) {
+		abortWithError(c, http.StatusNotFound, "Message not found")
+		return
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Failed to load message")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "Success",
+		"data": gin.H{
+			"message_id":  message.ID,
+			"received_by": message.ReceivedBy,
+			"seen_by":     message.SeenBy,
+		},
+	})
+}
@@ -0,0 +1,179 @@
+package chat
+
+import (
+	"errors"
+	"main/chat/protocol"
+	"main/state"
+	"main/state/entity"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxReplayMessages caps the messages replayed per room, the rest is left
+	// to the history endpoint.
+	maxReplayMessages = 500
+	// receiptReplayWindow bounds how long before the cursor the user's own
+	// messages may have been sent to get their receipts replayed.
+	receiptReplayWindow = 24 * time.Hour
+	// resumeHoldTimeout is how long a connection opened with resume=1 holds
+	// live events back waiting for its resume frame.
+	resumeHoldTimeout = 10 * time.Second
+)
+
+var errConnectionClosed = errors.New("connection closed during replay")
+
+// handleResume replays, room by room and in order, the messages the client
+// missed after its cursors and the receipts its own messages got meanwhile.
+// Live events are held back until the replay is over and a resumed event is
+// sent, new messages already part of the replay being dropped.
+func handleResume(client *Client, command *protocol.ResumePayload) error {
+	client.beginReplay()
+	replayed := make(map[string]struct{})
+	defer client.endReplay(wasReplayed(replayed))
+
+	resumed := protocol.ResumedPayload{Rooms: make(map[string]protocol.ResumedRoom)}
+	for room, cursor := range command.Rooms {
+		if !hub.IsSubscribed(room, client) {
+			resumed.Skipped = append(resumed.Skipped, room)
+			continue
+		}
+		result, err := replayRoom(client, room, cursor, replayed)
+		if errors.Is(err, errMessageNotFound) {
+			resumed.Skipped = append(resumed.Skipped, room)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		resumed.Rooms[room] = result
+	}
+	sort.Strings(resumed.Skipped)
+
+	if !client.replay(protocol.NewEvent(protocol.EventResumed, "", resumed)) {
+		return errConnectionClosed
+	}
+	return nil
+}
+
+// wasReplayed tells the held message.new events already sent by the replay,
+// replayed holding the ids of the messages replayed.
+func wasReplayed(replayed map[string]struct{}) func(protocol.Event) bool {
+	return func(event protocol.Event) bool {
+		message, ok := event.Payload.(protocol.Message)
+		if !ok || event.Type != protocol.EventMessageNew {
+			return false
+		}
+		_, ok = replayed[message.ID]
+		return ok
+	}
+}
+
+// replayRoom sends the messages of the room following the cursor message,
+// tombstones included, then the missed receipts.
+func replayRoom(client *Client, room string, cursor protocol.ResumeCursor, replayed map[string]struct{}) (protocol.ResumedRoom, error) {
+	db := state.GetConnection()
+	last, err := findCursor(db, room, cursor)
+	if err != nil {
+		return protocol.ResumedRoom{}, err
+	}
+
+	var messages []entity.Message
+	err = db.Where("chat_room_id = ? AND seq > ?", room, last.Seq).
+		Order("seq ASC").
+		Limit(maxReplayMessages + 1).
+		Find(&messages).Error
+	if err != nil {
+		return protocol.ResumedRoom{}, err
+	}
+	result := protocol.ResumedRoom{HasMore: len(messages) > maxReplayMessages}
+	if result.HasMore {
+		messages = messages[:maxReplayMessages]
+		result.After = messageCursor(messages[len(messages)-1].Seq).String()
+	}
+
+	for _, message := range messages {
+		if !client.replay(protocol.NewEvent(protocol.EventMessageNew, room, toProtocolMessage(message))) {
+			return result, errConnectionClosed
+		}
+		replayed[message.ID] = struct{}{}
+	}
+	result.Replayed = len(messages)
+
+	return result, replayReceipts(client, room, last.SentAt)
+}
+
+// findCursor returns the message the cursor points to. A cursor pointing to
+// no message stands before the first one, seq 0, so the whole room is
+// replayed.
+func findCursor(db *gorm.DB, room string, cursor protocol.ResumeCursor) (entity.Message, error) {
+	var last entity.Message
+	if cursor.LastSeq == 0 && cursor.LastMessageID == "" {
+		return last, nil
+	}
+	query := db.Where("chat_room_id = ?", room)
+	if cursor.LastSeq > 0 {
+		query = query.Where("seq = ?", cursor.LastSeq)
+	} else if uuid.Validate(cursor.LastMessageID) == nil {
+		query = query.Where("id = ?", cursor.LastMessageID)
+	} else {
+		return last, errMessageNotFound
+	}
+
+	err := query.First(&last).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return last, errMessageNotFound
+	}
+	return last, err
+}
+
+// replayReceipts sends the receipts the user's recent messages in the room got
+// after since, every receipt when since is zero. Receipts the client already
+// has may be sent again.
+func replayReceipts(client *Client, room string, since time.Time) error {
+	query := state.GetConnection().
+		Select("id", "received_by", "seen_by").
+		Where("chat_room_id = ? AND author_id = ? AND deleted_at IS NULL", room, client.UserID)
+	// A replay from the first message has no cursor time to bound it
+	if !since.IsZero() {
+		query = query.Where("sent_at > ?", since.Add(-receiptReplayWindow))
+	}
+	var messages []entity.Message
+	err := query.
+		Order("sent_at DESC").
+		Limit(maxReplayMessages).
+		Find(&messages).Error
+	if err != nil {
+		return err
+	}
+
+	var missed []protocol.ReceiptPayload
+	collect := func(messageID string, receipts entity.Receipts, status string) {
+		for userID, at := range receipts {
+			if userID != client.UserID && at.After(since) {
+				missed = append(missed, protocol.ReceiptPayload{MessageID: messageID, UserID: userID, Status: status, At: at})
+			}
+		}
+	}
+	for _, message := range messages {
+		collect(message.ID, message.ReceivedBy, protocol.AckDelivered)
+		collect(message.ID, message.SeenBy, protocol.AckRead)
+	}
+	// A read receipt shares its time with the delivered one it implied
+	sort.SliceStable(missed, func(i, j int) bool {
+		if missed[i].At.Equal(missed[j].At) {
+			return missed[i].Status == protocol.AckDelivered && missed[j].Status == protocol.AckRead
+		}
+		return missed[i].At.Before(missed[j].At)
+	})
+
+	for _, r := range missed {
+		if !client.replay(protocol.NewEvent(protocol.EventReceipt, room, r)) {
+			return errConnectionClosed
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package chat
+
+import (
+	"main/chat/protocol"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func mockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	conn, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	assert.NoError(t, err)
+	return db, mock
+}
+
+func TestFindCursor(t *testing.T) {
+	sentAt := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	messageRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "chat_room_id", "seq", "sent_at"}).
+			AddRow("7b0f6a4e-3f0e-4d6c-9a43-0d4b1f0d2c11", "general", 41, sentAt)
+	}
+
+	t.Run("Empty cursor replays from the first message", func(t *testing.T) {
+		last, err := findCursor(nil, "dm", protocol.ResumeCursor{})
+
+		assert.NoError(t, err)
+		assert.Zero(t, last.Seq)
+		assert.True(t, last.SentAt.IsZero())
+	})
+
+	t.Run("Seq cursor is looked up in the room", func(t *testing.T) {
+		db, mock := mockDB(t)
+		mock.ExpectQuery(regexp.QuoteMeta(`WHERE chat_room_id = $1 AND seq = $2`)).
+			WithArgs("general", 41, 1).
+			WillReturnRows(messageRows())
+
+		last, err := findCursor(db, "general", protocol.ResumeCursor{LastSeq: 41})
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(41), last.Seq)
+		assert.Equal(t, sentAt, last.SentAt)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Message id cursor is looked up in the room", func(t *testing.T) {
+		db, mock := mockDB(t)
+		mock.ExpectQuery(regexp.QuoteMeta(`WHERE chat_room_id = $1 AND id = $2`)).
+			WithArgs("general", "7b0f6a4e-3f0e-4d6c-9a43-0d4b1f0d2c11", 1).
+			WillReturnRows(messageRows())
+
+		last, err := findCursor(db, "general", protocol.ResumeCursor{LastMessageID: "7b0f6a4e-3f0e-4d6c-9a43-0d4b1f0d2c11"})
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(41), last.Seq)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Cursor outside the room is not found", func(t *testing.T) {
+		db, mock := mockDB(t)
+		mock.ExpectQuery(regexp.QuoteMeta(`WHERE chat_room_id = $1 AND seq = $2`)).
+			WithArgs("random", 41, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		_, err := findCursor(db, "random", protocol.ResumeCursor{LastSeq: 41})
+
+		assert.ErrorIs(t, err, errMessageNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Malformed message id is not found without a query", func(t *testing.T) {
+		db, mock := mockDB(t)
+
+		_, err := findCursor(db, "general", protocol.ResumeCursor{LastMessageID: "not-a-uuid"})
+
+		assert.ErrorIs(t, err, errMessageNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestResumeReplay(t *testing.T) {
+	t.Run("Held live messages already replayed are dropped", func(t *testing.T) {
+		client := newClient("alice", nil, getSocketConfig())
+		message := func(id string) protocol.Event {
+			return protocol.NewEvent(protocol.EventMessageNew, "general", protocol.Message{ID: id, Room: "general"})
+		}
+		typing := protocol.NewEvent(protocol.EventTypingStart, "general", protocol.TypingPayload{State: protocol.TypingStart})
+
+		client.beginReplay()
+		client.Send(message("m-2"))
+		client.Send(typing)
+		client.Send(message("m-3"))
+		assert.True(t, client.replay(message("m-1")))
+		assert.True(t, client.replay(message("m-2")))
+		client.endReplay(wasReplayed(map[string]struct{}{"m-1": {}, "m-2": {}}))
+
+		var order []string
+		for {
+			event, ok := client.outbox.pop()
+			if !ok {
+				break
+			}
+			if m, isMessage := event.Payload.(protocol.Message); isMessage {
+				order = append(order, m.ID)
+			} else {
+				order = append(order, event.Type)
+			}
+		}
+		assert.Equal(t, []string{"m-1", "m-2", protocol.EventTypingStart, "m-3"}, order)
+	})
+}
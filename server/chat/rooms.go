@@ -0,0 +1,352 @@
+package chat
+
+import (
+	"errors"
+	"main/chat/protocol"
+	"main/state"
+	"main/state/entity"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const maxRoomNameLength = 255
+
+// JoinGroupHandler adds the caller to the room. Joining a room twice is a no-op.
+func JoinGroupHandler(c *gin.Context) {
+	userID, ok := requestUserID(c)
+	if !ok {
+		return
+	}
+	roomID := c.Param("id")
+	if !requestGroup(c, roomID) {
+		return
+	}
+
+	member := entity.RoomMember{RoomID: roomID, UserID: userID, Role: entity.RoomRoleMember, JoinedAt: time.Now()}
+	result := state.GetConnection().Clauses(clause.OnConflict{DoNothing: true}).Create(&member)
+	if result.Error != nil {
+		abortWithError(c, http.StatusInternalServerError, "Failed to update room members")
+		return
+	}
+
+	if result.RowsAffected > 0 {
+		hub.SubscribeUser(roomID, userID)
+		hub.Broadcast(roomID, protocol.NewEvent(protocol.EventMemberJoined, roomID, protocol.MemberPayload{UserID: userID}))
+	}
+	respondMembers(c, roomID)
+}
+
+// LeaveGroupHandler removes the caller from the room. Leaving a room the
+// caller isn't in is a no-op. When the owner leaves, ownership passes on to
+// the longest standing admin or member.
+func LeaveGroupHandler(c *gin.Context) {
+	userID, ok := requestUserID(c)
+	if !ok {
+		return
+	}
+	roomID := c.Param("id")
+	if !requestGroup(c, roomID) {
+		return
+	}
+
+	left := false
+	err := state.GetConnection().Transaction(func(tx *gorm.DB) error {
+		member, err := getMember(tx.Clauses(clause.Locking{Strength: "UPDATE"}), roomID, userID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(member).Error; err != nil {
+			return err
+		}
+		left = true
+		if member.Role == entity.RoomRoleOwner {
+			return promoteSuccessor(tx, roomID)
+		}
+		return nil
+	})
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Failed to update room members")
+		return
+	}
+
+	if left {
+		hub.Broadcast(roomID, protocol.NewEvent(protocol.EventMemberLeft, roomID, protocol.MemberPayload{UserID: userID}))
+		hub.UnsubscribeUser(roomID, userID)
+	}
+	respondMembers(c, roomID)
+}
+
+func respondMembers(c *gin.Context, roomID string) {
+	members, err := listMembers(state.GetConnection(), roomID)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Failed to load room members")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "Success",
+		"data": gin.H{
+			"room":    roomID,
+			"members": toProtocolMembers(members),
+		},
+	})
+}
+
+// CreateRoomHandler creates a room owned by the caller. The caller is always
+// a member, on top of the requested initial members.
+func CreateRoomHandler(c *gin.Context) {
+	userID, ok := requestUserID(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		Name    string   `json:"name"`
+		Members []string `json:"members"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithError(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	name, valid := validRoomName(req.Name)
+	if !valid {
+		abortWithError(c, http.StatusBadRequest, "name is required and can't exceed 255 characters")
+		return
+	}
+
+	userIDs := []string{userID}
+	for _, member := range req.Members {
+		if !slices.Contains(userIDs, member) {
+			userIDs = append(userIDs, member)
+		}
+	}
+	var count int64
+	if err := state.GetConnection().Model(&entity.User{}).Where("id IN ?", userIDs).Count(&count).Error; err != nil || count != int64(len(userIDs)) {
+		abortWithError(c, http.StatusBadRequest, "members contain unknown users")
+		return
+	}
+
+	room := entity.ChatRoom{ID: uuid.New().String(), Name: name, Kind: entity.ChatRoomKindGroup}
+	members := make([]entity.RoomMember, 0, len(userIDs))
+	joinedAt := time.Now()
+	for _, member := range userIDs {
+		role := entity.RoomRoleMember
+		if member == userID {
+			role = entity.RoomRoleOwner
+		}
+		members = append(members, entity.RoomMember{RoomID: room.ID, UserID: member, Role: role, JoinedAt: joinedAt})
+	}
+	err := state.GetConnection().Transaction(func(tx *gorm.DB) error {
+		if err := state.Create[entity.ChatRoom](tx, &room); err != nil {
+			return err
+		}
+		return state.Create[[]entity.RoomMember](tx, &members)
+	})
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Failed to create room")
+		return
+	}
+	for _, member := range userIDs {
+		hub.SubscribeUser(room.ID, member)
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status": "Success",
+		"data":   toProtocolRoom(room, members),
+	})
+}
+
+// ListRoomsHandler lists the rooms the caller belongs to.
+func ListRoomsHandler(c *gin.Context) {
+	userID, ok := requestUserID(c)
+	if !ok {
+		return
+	}
+
+	var rooms []entity.ChatRoom
+	filter := state.GetConnection().
+		Joins("JOIN room_members ON room_members.room_id = chat_rooms.id").
+		Where("room_members.user_id = ?", userID).
+		Order("chat_rooms.name")
+	if err := state.List[entity.ChatRoom](filter, &rooms); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Failed to load rooms")
+		return
+	}
+
+	roomIDs := make([]string, 0, len(rooms))
+	for _, room := range rooms {
+		roomIDs = append(roomIDs, room.ID)
+	}
+	var members []entity.RoomMember
+	if err := state.List[entity.RoomMember](state.GetConnection().Where("room_id IN ?", roomIDs).Order("joined_at, user_id"), &members); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Failed to load rooms")
+		return
+	}
+	membersByRoom := make(map[string][]entity.RoomMember, len(rooms))
+	for _, member := range members {
+		membersByRoom[member.RoomID] = append(membersByRoom[member.RoomID], member)
+	}
+
+	items := make([]protocol.Room, 0, len(rooms))
+	for _, room := range rooms {
+		items = append(items, toProtocolRoom(room, membersByRoom[room.ID]))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status": "Success",
+		"data":   items,
+	})
+}
+
+func GetRoomHandler(c *gin.Context) {
+	room, _, ok := requestRoom(c)
+	if !ok {
+		return
+	}
+
+	respondRoom(c, room)
+}
+
+// RenameRoomHandler renames a room, any member may do it.
+func RenameRoomHandler(c *gin.Context) {
+	room, _, ok := requestRoom(c)
+	if !ok {
+		return
+	}
+	if room.Kind == entity.ChatRoomKindDirect {
+		abortWithError(c, http.StatusForbidden, "Direct conversations can't be renamed")
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		abortWithError(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	name, valid := validRoomName(req.Name)
+	if !valid {
+		abortWithError(c, http.StatusBadRequest, "name is required and can't exceed 255 characters")
+		return
+	}
+
+	// Only the name is written, saving the whole row could set last_seq back
+	room.Name = name
+	if err := state.GetConnection().Model(room).Update("name", name).Error; err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Failed to rename room")
+		return
+	}
+	members, err := listMembers(state.GetConnection(), room.ID)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Failed to load room members")
+		return
+	}
+	hub.Broadcast(room.ID, protocol.NewEvent(protocol.EventRoomUpdated, room.ID, toProtocolRoom(*room, members)))
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "Success",
+		"data":   toProtocolRoom(*room, members),
+	})
+}
+
+// DeleteRoomHandler deletes a room along with its messages, owner only.
+func DeleteRoomHandler(c *gin.Context) {
+	room, member, ok := requestRoom(c)
+	if !ok {
+		return
+	}
+	if member.Role != entity.RoomRoleOwner {
+		abortWithError(c, http.StatusForbidden, "Only the owner can delete the room")
+		return
+	}
+
+	if err := state.Delete[entity.ChatRoom](state.GetConnection(), room.ID, &entity.ChatRoom{}); err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Failed to delete room")
+		return
+	}
+	hub.Broadcast(room.ID, protocol.NewEvent(protocol.EventRoomDeleted, room.ID, nil))
+	hub.CloseRoom(room.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "Success",
+		"data":   gin.H{"room": room.ID},
+	})
+}
+
+// requestRoom loads the room addressed by the :id param along with the
+// caller's membership, aborting unless it exists and the caller belongs to it.
+func requestRoom(c *gin.Context) (*entity.ChatRoom, *entity.RoomMember, bool) {
+	userID, ok := requestUserID(c)
+	if !ok {
+		return nil, nil, false
+	}
+
+	var room entity.ChatRoom
+	err := state.GetByID[entity.ChatRoom](state.GetConnection(), c.Param("id"), &room)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		abortWithError(c, http.StatusNotFound, "Room not found")
+		return nil, nil, false
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Failed to load room")
+		return nil, nil, false
+	}
+	member, err := getMember(state.GetConnection(), room.ID, userID)
+	if err != nil {
+		abortWithError(c, http.StatusForbidden, "Not a member of this room")
+		return nil, nil, false
+	}
+	return &room, member, true
+}
+
+// requestGroup aborts unless the room exists and is a group, direct
+// conversations can't be joined or left.
+func requestGroup(c *gin.Context, roomID string) bool {
+	var room entity.ChatRoom
+	err := state.GetByID[entity.ChatRoom](state.GetConnection(), roomID, &room)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		abortWithError(c, http.StatusNotFound, "Room not found")
+		return false
+	}
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Failed to load room")
+		return false
+	}
+	if room.Kind == entity.ChatRoomKindDirect {
+		abortWithError(c, http.StatusForbidden, "Direct conversations can't be joined or left")
+		return false
+	}
+	return true
+}
+
+func respondRoom(c *gin.Context, room *entity.ChatRoom) {
+	members, err := listMembers(state.GetConnection(), room.ID)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, "Failed to load room members")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "Success",
+		"data":   toProtocolRoom(*room, members),
+	})
+}
+
+func validRoomName(name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	return name, name != "" && len([]rune(name)) <= maxRoomNameLength
+}
+
+func toProtocolRoom(room entity.ChatRoom, members []entity.RoomMember) protocol.Room {
+	return protocol.Room{ID: room.ID, Name: room.Name, Kind: room.Kind, Members: toProtocolMembers(members)}
+}
@@ -0,0 +1,88 @@
+package chat
+
+import (
+	"main/chat/protocol"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// reconnectSpread is the window the reconnect hints of going_away events
+	// are spread over, so clients don't all hit the remaining servers at once.
+	reconnectSpread = 5 * time.Second
+	// drainRetryAfter is the Retry-After of connections refused while draining.
+	drainRetryAfter = 5 * time.Second
+	// goingAwayFlushInterval is how often Drain checks whether the going_away
+	// events were written.
+	goingAwayFlushInterval = 50 * time.Millisecond
+)
+
+var draining atomic.Bool
+
+// Draining reports whether the server is shutting down and refuses new
+// connections.
+func Draining() bool {
+	return draining.Load()
+}
+
+// Drain stops accepting connections and sends server.going_away to every
+// connected client. Once their outboxes are flushed, or after timeout, the
+// clients are closed with 1001 Going Away.
+func Drain(timeout time.Duration) {
+	draining.Store(true)
+
+	clients := hub.Clients()
+	for _, client := range clients {
+		hint := rand.Int64N(reconnectSpread.Milliseconds())
+		client.Send(protocol.NewEvent(protocol.EventGoingAway, "", protocol.GoingAwayPayload{ReconnectAfterMs: hint}))
+	}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) && !flushed(clients) {
+		time.Sleep(goingAwayFlushInterval)
+	}
+	for _, client := range clients {
+		client.closeWith(websocket.CloseGoingAway, "server shutting down")
+	}
+}
+
+// Close writes the pending receipts and disconnects from the broker. It is
+// called last, once the worker pool is drained.
+func Close() error {
+	err := receipts.Flush()
+	if closeErr := hub.Broker().Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// rejectDraining answers 503 when the server is draining, telling the client
+// to connect again after drainRetryAfter.
+func rejectDraining(c *gin.Context) bool {
+	if !Draining() {
+		return false
+	}
+	c.Header("Retry-After", strconv.Itoa(int(drainRetryAfter.Seconds())))
+	abortWithError(c, http.StatusServiceUnavailable, "Server is shutting down, connect again")
+	return true
+}
+
+func flushed(clients []*Client) bool {
+	for _, client := range clients {
+		select {
+		case <-client.done:
+			continue
+		default:
+		}
+		if client.outbox.len() > 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -1,5 +0,0 @@
-package chat
-
-import "github.com/gorilla/websocket"
-
-type ChatRoomConnState map[string]map[string]*websocket.Conn
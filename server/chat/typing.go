@@ -0,0 +1,78 @@
+package chat
+
+import (
+	"main/chat/protocol"
+	"sync"
+	"time"
+)
+
+// typingTimeout is how long a typing.start lasts unless the client repeats it.
+const typingTimeout = 6 * time.Second
+
+type typingKey struct {
+	room   string
+	userID string
+}
+
+// typingTracker keeps the typing state of users per room in memory only and
+// emits typing.stop on its own when a client stops refreshing it.
+type typingTracker struct {
+	mu     sync.Mutex
+	timers map[typingKey]*time.Timer
+}
+
+var typing = &typingTracker{timers: make(map[typingKey]*time.Timer)}
+
+// Start announces the user is typing in the room, or extends the current
+// announcement when there is one.
+func (t *typingTracker) Start(room string, userID string) {
+	key := typingKey{room: room, userID: userID}
+
+	t.mu.Lock()
+	if timer, ok := t.timers[key]; ok {
+		timer.Reset(typingTimeout)
+		t.mu.Unlock()
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(typingTimeout, func() {
+		t.expire(key, &timer)
+	})
+	t.timers[key] = timer
+	t.mu.Unlock()
+
+	hub.BroadcastExcept(room, userID, protocol.NewEvent(protocol.EventTypingStart, room, protocol.TypingEventPayload{UserID: userID}))
+}
+
+// Stop announces the user stopped typing in the room, if they were.
+func (t *typingTracker) Stop(room string, userID string) {
+	key := typingKey{room: room, userID: userID}
+
+	t.mu.Lock()
+	timer, ok := t.timers[key]
+	if ok {
+		timer.Stop()
+		delete(t.timers, key)
+	}
+	t.mu.Unlock()
+
+	if ok {
+		hub.BroadcastExcept(room, userID, protocol.NewEvent(protocol.EventTypingStop, room, protocol.TypingEventPayload{UserID: userID}))
+	}
+}
+
+// expire reads the timer through a pointer under t.mu, as it is assigned
+// after time.AfterFunc returns.
+func (t *typingTracker) expire(key typingKey, timer **time.Timer) {
+	t.mu.Lock()
+	current, ok := t.timers[key]
+	// The key may have been stopped and started again meanwhile
+	if !ok || current != *timer {
+		t.mu.Unlock()
+		return
+	}
+	delete(t.timers, key)
+	t.mu.Unlock()
+
+	hub.BroadcastExcept(key.room, key.userID, protocol.NewEvent(protocol.EventTypingStop, key.room, protocol.TypingEventPayload{UserID: key.userID}))
+}
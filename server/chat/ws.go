@@ -1,20 +1,117 @@
 package chat
 
 import (
-	chat "main/chat/types"
+	"errors"
+	"main/chat/protocol"
+	"main/lib"
+	"main/session"
+	"main/state"
 	"main/state/entity"
+	"net/http"
+	"time"
 
+	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
 )
 
-var wsConnectionsMap chat.ChatRoomConnState
+// Close codes sent when the handshake can't be attributed to a user. Browsers
+// can't read the HTTP status of a failed upgrade, so the socket is upgraded
+// first and closed right away with one of these.
+const (
+	CloseUnauthorized = 4401
+	CloseTokenExpired = 4419
+)
+
+var (
+	upgrader = websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			return true // Adjust this in production
+		},
+		// permessage-deflate, used when the client offers it
+		EnableCompression: true,
+	}
+)
+
+func WsUpgradeHandler(c *gin.Context) {
+	if rejectDraining(c) {
+		return
+	}
+	accessToken, subprotocol := session.RequestAccessToken(c.Request)
+	// Only one subprotocol can be accepted, the encoding wins over the token
+	codec, negotiated := protocol.CodecFor(websocket.Subprotocols(c.Request))
+	if negotiated {
+		subprotocol = codec.Subprotocol
+	}
+	var responseHeader http.Header
+	if subprotocol != "" {
+		responseHeader = http.Header{"Sec-WebSocket-Protocol": {subprotocol}}
+	}
+
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, responseHeader)
+	if err != nil {
+		// Upgrade has already replied with an HTTP error
+		lib.GetLogger().Warn("websocket upgrade failed", zap.Error(err))
+		return
+	}
+
+	user, code, reason := authenticate(accessToken)
+	if user == nil {
+		closeWithCode(conn, code, reason)
+		return
+	}
+
+	config := getSocketConfig()
+	prepareRead(conn, config)
+	prepareCompression(conn, config)
+	client := newClient(user.ID, conn, config)
+	client.codec = codec
+	go client.writePump()
+	disconnect := connect(client, c.Query("resume") == "1")
+	defer disconnect()
+
+	for {
+		_, bytes, err := conn.ReadMessage()
+		if err != nil {
+			if reason := evictionReason(err); reason != "" {
+				lib.RecordWebSocketEviction(reason)
+			}
+			break
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(config.pongWait))
+		handleFrame(client, codec, bytes)
+	}
+}
+
+// authenticate resolves the user owning accessToken. When it can't, the close
+// code and reason to reject the socket with are returned instead.
+func authenticate(accessToken string) (*entity.User, int, string) {
+	if accessToken == "" {
+		lib.RecordAuthFailure(session.AuthMissingToken)
+		return nil, CloseUnauthorized, "missing access token"
+	}
+
+	claims, err := session.ParseToken(accessToken)
+	if errors.Is(err, session.ErrTokenExpired) {
+		lib.RecordAuthFailure(session.AuthTokenExpired)
+		return nil, CloseTokenExpired, "access token expired"
+	}
+	if err != nil {
+		lib.RecordAuthFailure(session.AuthInvalidToken)
+		return nil, CloseUnauthorized, "invalid access token"
+	}
+
+	var user entity.User
+	if err := state.GetByID[entity.User](state.GetConnection(), claims.UserID, &user); err != nil {
+		lib.RecordAuthFailure(session.AuthUnknownUser)
+		return nil, CloseUnauthorized, "unknown user"
+	}
 
-func AssignConnection(chatRoom entity.ChatRoom, connectionString *websocket.Conn, userID string) error {
-	wsConnectionsMap[chatRoom.ID][userID] = connectionString
-	return nil
+	return &user, 0, ""
 }
 
-func CloseConnection(chatRoom entity.ChatRoom, userID string) error {
-	wsConnectionsMap[chatRoom.ID][userID] = nil
-	return nil
+func closeWithCode(conn *websocket.Conn, code int, reason string) {
+	message := websocket.FormatCloseMessage(code, reason)
+	_ = conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+	_ = conn.Close()
 }
@@ -0,0 +1,29 @@
+-- Copies room membership from the chat_rooms.members JSONB array to the room_members table, which
+-- also records who owns a room. The earliest member listed becomes the owner, so every room keeps
+-- someone able to manage it. Array entries that don't match a user are dropped. The array stays
+-- until 002_drop_chat_room_members.sql, so the copy can be checked and redone.
+BEGIN;
+
+CREATE TABLE room_members (
+                              room_id VARCHAR(255) REFERENCES chat_rooms(id) ON DELETE CASCADE,
+                              user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+                              role VARCHAR(50) NOT NULL DEFAULT 'member',
+                              joined_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                              muted_until TIMESTAMP,
+                              last_read_message_id UUID REFERENCES messages(id) ON DELETE SET NULL,
+                              PRIMARY KEY (room_id, user_id)
+);
+
+CREATE INDEX room_members_user_id_idx ON room_members (user_id);
+
+INSERT INTO room_members (room_id, user_id, role)
+SELECT room_id, user_id, CASE WHEN ROW_NUMBER() OVER (PARTITION BY room_id ORDER BY position) = 1 THEN 'owner' ELSE 'member' END
+FROM (
+         SELECT DISTINCT ON (r.id, u.id) r.id AS room_id, u.id AS user_id, m.position
+         FROM chat_rooms r
+                  CROSS JOIN jsonb_array_elements_text(r.members) WITH ORDINALITY AS m(user_id, position)
+                  JOIN users u ON u.id::text = m.user_id
+         ORDER BY r.id, u.id, m.position
+     ) listed;
+
+COMMIT;
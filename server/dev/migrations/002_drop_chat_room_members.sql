@@ -0,0 +1,4 @@
+-- Drops the membership array replaced by the room_members table. Run it in a later deploy than
+-- 001_room_members.sql, once every server reads room_members and the copy was checked; until then
+-- the copy is undone by dropping room_members.
+ALTER TABLE chat_rooms DROP COLUMN members;
@@ -0,0 +1,2 @@
+-- Tells group rooms apart from direct (1:1) conversations, existing rooms are groups.
+ALTER TABLE chat_rooms ADD COLUMN kind VARCHAR(20) NOT NULL DEFAULT 'group';
@@ -0,0 +1,18 @@
+-- Keeps the history of edited and deleted messages. Deleted messages keep their row as a tombstone
+-- with an empty text, the text they had is moved to message_edits.
+BEGIN;
+
+ALTER TABLE messages ADD COLUMN edited_at TIMESTAMP;
+
+CREATE TABLE message_edits (
+                               id UUID PRIMARY KEY,
+                               message_id UUID REFERENCES messages(id) ON DELETE CASCADE,
+                               editor_id UUID REFERENCES users(id) ON DELETE SET NULL,
+                               previous_text TEXT NOT NULL,
+                               deleted BOOLEAN NOT NULL DEFAULT FALSE,
+                               edited_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX message_edits_message_id_idx ON message_edits (message_id);
+
+COMMIT;
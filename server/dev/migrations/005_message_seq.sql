@@ -0,0 +1,28 @@
+-- Numbers the messages of every room from 1 without gaps. chat_rooms.last_seq holds the number of the
+-- latest message, messages take the next one when they are stored.
+BEGIN;
+
+ALTER TABLE chat_rooms ADD COLUMN last_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE messages ADD COLUMN seq BIGINT;
+
+UPDATE messages AS m
+SET seq = numbered.seq
+FROM (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY chat_room_id ORDER BY sent_at, id) AS seq
+    FROM messages
+) AS numbered
+WHERE m.id = numbered.id;
+
+UPDATE chat_rooms AS r
+SET last_seq = latest.seq
+FROM (
+    SELECT chat_room_id, MAX(seq) AS seq
+    FROM messages
+    GROUP BY chat_room_id
+) AS latest
+WHERE r.id = latest.chat_room_id;
+
+ALTER TABLE messages ALTER COLUMN seq SET NOT NULL;
+CREATE UNIQUE INDEX messages_room_seq_idx ON messages (chat_room_id, seq);
+
+COMMIT;
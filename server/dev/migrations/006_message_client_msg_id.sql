@@ -0,0 +1,9 @@
+-- Keeps the id clients send messages with, so a retried send can be recognized and answered with the
+-- stored message instead of storing it twice.
+BEGIN;
+
+ALTER TABLE messages ADD COLUMN client_msg_id VARCHAR(64) NOT NULL DEFAULT '';
+
+CREATE INDEX messages_author_client_msg_id_idx ON messages (author_id, client_msg_id) WHERE client_msg_id <> '';
+
+COMMIT;
@@ -0,0 +1,13 @@
+-- Holds the payloads the postgres broker can't fit in a NOTIFY, the notification carrying the row id
+-- instead. Rows are only needed until every server fetched them and are swept after a minute.
+BEGIN;
+
+CREATE TABLE broker_messages (
+    id BIGSERIAL PRIMARY KEY,
+    payload BYTEA NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX broker_messages_created_at_idx ON broker_messages (created_at);
+
+COMMIT;
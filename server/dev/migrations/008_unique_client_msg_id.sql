@@ -0,0 +1,20 @@
+-- Makes a client message id unique per author, so a retried send racing the original on another
+-- server fails its insert instead of storing the message twice. Duplicates already stored keep their
+-- text but lose the id, the earliest message keeping it.
+BEGIN;
+
+UPDATE messages SET client_msg_id = ''
+WHERE client_msg_id <> '' AND id IN (
+    SELECT id FROM (
+        SELECT id, ROW_NUMBER() OVER (PARTITION BY author_id, client_msg_id ORDER BY sent_at, seq) AS n
+        FROM messages
+        WHERE client_msg_id <> ''
+    ) ranked
+    WHERE n > 1
+);
+
+DROP INDEX messages_author_client_msg_id_idx;
+
+CREATE UNIQUE INDEX messages_author_client_msg_id_idx ON messages (author_id, client_msg_id) WHERE client_msg_id <> '';
+
+COMMIT;
@@ -20,17 +20,55 @@ CREATE TABLE user_sessions (
 CREATE TABLE chat_rooms (
                             id VARCHAR(255) PRIMARY KEY,
                             name VARCHAR(255) NOT NULL,
-                            members JSONB NOT NULL
+                            kind VARCHAR(20) NOT NULL DEFAULT 'group',
+                            last_seq BIGINT NOT NULL DEFAULT 0
 );
 
 CREATE TABLE messages (
                           id UUID PRIMARY KEY,
                           chat_room_id varchar(255) REFERENCES chat_rooms(id) ON DELETE CASCADE,
+                          seq BIGINT NOT NULL,
                           author_id UUID REFERENCES users(id) ON DELETE CASCADE,
+                          client_msg_id VARCHAR(64) NOT NULL DEFAULT '',
                           text TEXT NOT NULL,
                           seen_by JSONB NOT NULL,
                           received_by JSONB NOT NULL,
                           sent_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                          edited_at TIMESTAMP,
                           deleted_at TIMESTAMP
 );
+
+CREATE UNIQUE INDEX messages_room_seq_idx ON messages (chat_room_id, seq);
+CREATE UNIQUE INDEX messages_author_client_msg_id_idx ON messages (author_id, client_msg_id) WHERE client_msg_id <> '';
+
+CREATE TABLE message_edits (
+                               id UUID PRIMARY KEY,
+                               message_id UUID REFERENCES messages(id) ON DELETE CASCADE,
+                               editor_id UUID REFERENCES users(id) ON DELETE SET NULL,
+                               previous_text TEXT NOT NULL,
+                               deleted BOOLEAN NOT NULL DEFAULT FALSE,
+                               edited_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX message_edits_message_id_idx ON message_edits (message_id);
+
+CREATE TABLE room_members (
+                              room_id VARCHAR(255) REFERENCES chat_rooms(id) ON DELETE CASCADE,
+                              user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+                              role VARCHAR(50) NOT NULL DEFAULT 'member',
+                              joined_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+                              muted_until TIMESTAMP,
+                              last_read_message_id UUID REFERENCES messages(id) ON DELETE SET NULL,
+                              PRIMARY KEY (room_id, user_id)
+);
+
+CREATE INDEX room_members_user_id_idx ON room_members (user_id);
+
+CREATE TABLE broker_messages (
+                                 id BIGSERIAL PRIMARY KEY,
+                                 payload BYTEA NOT NULL,
+                                 created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX broker_messages_created_at_idx ON broker_messages (created_at);
 verification_token varchar(255)
\ No newline at end of file
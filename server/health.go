@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"main/chat"
+	"main/lib"
+	"main/state"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Set at build time with -ldflags "-X main.version=... -X main.commit=..."
+var (
+	version = "dev"
+	commit  = "unknown"
+)
+
+const (
+	// readyCheckTimeout bounds each dependency check of /readyz
+	readyCheckTimeout = 2 * time.Second
+	// maxQueueSaturation is the share of the worker pool queue that may be
+	// filled before the server stops taking traffic
+	maxQueueSaturation = 0.9
+)
+
+// healthzHandler answers as long as the process serves requests, restarting
+// it wouldn't fix a failing dependency.
+func healthzHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": "Success",
+		"data":   gin.H{"uptime": uptime().String()},
+	})
+}
+
+// readyzHandler tells whether the server should get traffic: the database
+// and the broker answer, the worker pool keeps up and it isn't draining.
+// Every check is reported, failing ones with their error.
+func readyzHandler(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readyCheckTimeout)
+	defer cancel()
+
+	checks := gin.H{
+		"database":    checkResult(state.Ping(ctx)),
+		"broker":      checkResult(chat.GetHub().Broker().Ping(ctx)),
+		"worker_pool": checkResult(checkWorkerPool()),
+		"draining":    "ok",
+	}
+	if chat.Draining() {
+		checks["draining"] = "server is shutting down"
+	}
+
+	for _, result := range checks {
+		if result != "ok" {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"status": "Error",
+				"data":   gin.H{"checks": checks},
+			})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status": "Success",
+		"data":   gin.H{"checks": checks},
+	})
+}
+
+func statusHandler(c *gin.Context) {
+	metrics := lib.GetMetrics()
+	active, pending, processing := lib.GetConfig().WP.GetMetrics()
+	c.JSON(http.StatusOK, gin.H{
+		"status": "Success",
+		"data": gin.H{
+			"uptime":                uptime().String(),
+			"started_at":            metrics.StartTime,
+			"version":               version,
+			"commit":                commit,
+			"go_version":            runtime.Version(),
+			"draining":              chat.Draining(),
+			"websocket_connections": metrics.WebSocketConnections,
+			"worker_pool": gin.H{
+				"workers":    active,
+				"pending":    pending,
+				"processing": processing,
+				"queue_size": lib.GetConfig().WP.QueueSize(),
+			},
+		},
+	})
+}
+
+var errWorkerPoolSaturated = errors.New("worker pool queue is saturated")
+
+// checkWorkerPool fails when the queue is nearly full, new commands would
+// block the read loops.
+func checkWorkerPool() error {
+	wp := lib.GetConfig().WP
+	_, pending, _ := wp.GetMetrics()
+	if float64(pending) >= maxQueueSaturation*float64(wp.QueueSize()) {
+		return errWorkerPoolSaturated
+	}
+	return nil
+}
+
+func checkResult(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return "ok"
+}
+
+func uptime() time.Duration {
+	return time.Since(lib.GetMetrics().StartTime).Round(time.Second)
+}
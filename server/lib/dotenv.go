@@ -3,6 +3,7 @@ package lib
 import (
 	"os"
 	"strconv"
+	"strings"
 )
 
 var envCache = make(map[string][]byte)
@@ -26,3 +27,27 @@ func GetIntDotEnv(key string) int {
 	}
 	return env
 }
+
+// GetIntDotEnvDefault returns fallback when the key isn't set.
+func GetIntDotEnvDefault(key string, fallback int) int {
+	value := GetDotEnv(key)
+	if len(value) == 0 {
+		return fallback
+	}
+	env, err := strconv.Atoi(string(value))
+	if err != nil {
+		panic("Invalid " + key + " value")
+	}
+	return env
+}
+
+// GetListDotEnv splits a comma separated value, nil when the key isn't set.
+func GetListDotEnv(key string) []string {
+	var list []string
+	for _, item := range strings.Split(string(GetDotEnv(key)), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
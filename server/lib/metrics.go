@@ -0,0 +1,160 @@
+package lib
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "pchat"
+
+// Registry holds the collectors of the server, the worker pool ones being
+// added by RegisterWorkerPoolMetrics.
+var Registry = prometheus.NewRegistry()
+
+// Prometheus collectors, updated by the Record* functions next to the
+// ServerMetrics they keep.
+var (
+	wsConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "websocket_connections",
+		Help:      "Connected clients, fallback transports included.",
+	})
+	wsEvictions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "websocket_evictions_total",
+		Help:      "Connections closed by the server, by reason.",
+	}, []string{"reason"})
+	droppedEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "dropped_events_total",
+		Help:      "Events that never reached a client, by reason.",
+	}, []string{"reason"})
+	messagesIn = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_received_total",
+		Help:      "Frames received from clients, by command type.",
+	}, []string{"type"})
+	messagesOut = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "messages_sent_total",
+		Help:      "Events written to clients, by event type.",
+	}, []string{"type"})
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests, by method, route and status.",
+	}, []string{"method", "route", "status"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency, by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+	dbDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Database query latency, by operation and table.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation", "table"})
+	authFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "auth_failures_total",
+		Help:      "Rejected authentications, by reason.",
+	}, []string{"reason"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		wsConnections,
+		wsEvictions,
+		droppedEvents,
+		messagesIn,
+		messagesOut,
+		httpRequests,
+		httpDuration,
+		dbDuration,
+		authFailures,
+	)
+}
+
+// MetricsHandler serves the collectors of the registry in the Prometheus text
+// format.
+func MetricsHandler(registry *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry})
+}
+
+// RegisterWorkerPoolMetrics exposes the counters of the worker pool on the
+// registry, read at scrape time.
+func RegisterWorkerPoolMetrics(registry prometheus.Registerer, wp *WorkerPoolImpl) {
+	gauge := func(name string, help string, value func(active, pending, running int64) int64) prometheus.Collector {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "worker_pool",
+			Name:      name,
+			Help:      help,
+		}, func() float64 {
+			return float64(value(wp.GetMetrics()))
+		})
+	}
+	registry.MustRegister(
+		gauge("workers", "Running workers.", func(active, _, _ int64) int64 { return active }),
+		gauge("pending_tasks", "Tasks waiting in the queue.", func(_, pending, _ int64) int64 { return pending }),
+		gauge("running_tasks", "Tasks being processed.", func(_, _, running int64) int64 { return running }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "worker_pool",
+			Name:      "processed_tasks_total",
+			Help:      "Tasks processed since the start.",
+		}, func() float64 {
+			return float64(wp.Completed())
+		}),
+	)
+}
+
+// HTTPMetricsMiddleware records the count and latency of requests by route
+// template, requests matching no route being recorded as unmatched.
+func HTTPMetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		RecordHTTPRequest(c.Request.Method, route, c.Writer.Status(), time.Since(start))
+	}
+}
+
+// RecordMessageIn counts a frame received from a client.
+func RecordMessageIn(frameType string) {
+	messagesIn.WithLabelValues(frameType).Inc()
+}
+
+// RecordMessageOut counts an event written to a client.
+func RecordMessageOut(eventType string) {
+	messagesOut.WithLabelValues(eventType).Inc()
+}
+
+// RecordDBQuery observes the latency of a database query.
+func RecordDBQuery(operation string, table string, duration time.Duration) {
+	dbDuration.WithLabelValues(operation, table).Observe(duration.Seconds())
+}
+
+// RecordAuthFailure counts a rejected authentication.
+func RecordAuthFailure(reason string) {
+	authFailures.WithLabelValues(reason).Inc()
+}
+
+func recordHTTPMetrics(method string, route string, status int, duration time.Duration) {
+	httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	httpDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
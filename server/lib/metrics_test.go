@@ -0,0 +1,71 @@
+package lib
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Run("Requests are recorded by route template", func(t *testing.T) {
+		gin.SetMode(gin.TestMode)
+		r := gin.New()
+		r.Use(HTTPMetricsMiddleware())
+		r.GET("/chat/rooms/:id", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+		samples := []string{
+			`pchat_http_requests_total{method="GET",route="/chat/rooms/:id",status="204"}`,
+			`pchat_http_requests_total{method="GET",route="unmatched",status="404"}`,
+			`pchat_http_request_duration_seconds_count{method="GET",route="/chat/rooms/:id"}`,
+		}
+		before := scrape(t, Registry)
+
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/chat/rooms/general", nil))
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/nowhere", nil))
+
+		after := scrape(t, Registry)
+		for _, sample := range samples {
+			assert.Equal(t, sampleValue(before, sample)+1, sampleValue(after, sample), sample)
+		}
+	})
+
+	t.Run("Worker pool counters are read at scrape time", func(t *testing.T) {
+		registry := prometheus.NewRegistry()
+		wp := NewWorkerPool(WorkerPoolConfig{WorkerFn: func(Task[map[string]any]) {}, NumWorkers: 10})
+		RegisterWorkerPoolMetrics(registry, wp)
+		wp.ScaleUp(1)
+		wp.EnqueueTask(Task[map[string]any]{})
+		assert.True(t, wp.Drain(time.Second))
+
+		body := scrape(t, registry)
+		assert.Equal(t, float64(1), sampleValue(body, "pchat_worker_pool_processed_tasks_total"))
+		assert.Equal(t, float64(0), sampleValue(body, "pchat_worker_pool_pending_tasks"))
+	})
+}
+
+func scrape(t *testing.T, registry *prometheus.Registry) string {
+	recorder := httptest.NewRecorder()
+	MetricsHandler(registry).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, err := io.ReadAll(recorder.Body)
+	assert.NoError(t, err)
+	return string(body)
+}
+
+// sampleValue returns the value of the sample in the scraped body, 0 when it
+// isn't there yet.
+func sampleValue(body string, sample string) float64 {
+	for _, line := range strings.Split(body, "\n") {
+		if value, ok := strings.CutPrefix(line, sample+" "); ok {
+			v, _ := strconv.ParseFloat(value, 64)
+			return v
+		}
+	}
+	return 0
+}
@@ -14,7 +14,6 @@ import (
 )
 
 type ServerMetrics struct {
-	mu          sync.RWMutex
 	CPUUsage    float64
 	MemoryUsage float64
 	DiskUsage   float64
@@ -26,22 +25,30 @@ type ServerMetrics struct {
 		Processed int64
 	}
 	WebSocketConnections int
-	HTTPRequests         map[string]uint64
-	StartTime            time.Time
+	// WebSocketEvictions counts connections closed by the server, by reason
+	WebSocketEvictions map[string]uint64
+	// DroppedEvents counts events that never reached a client, by reason
+	DroppedEvents map[string]uint64
+	HTTPRequests  map[string]uint64
+	StartTime     time.Time
 }
 
 var (
-	metrics     ServerMetrics
+	metrics ServerMetrics
+	// metricsMu guards metrics, GetMetrics hands out copies
+	metricsMu   sync.RWMutex
 	metricsOnce sync.Once
 )
 
 func InitMonitor() {
 	metricsOnce.Do(func() {
 		metrics = ServerMetrics{
-			HTTPLatency:  make(map[string]time.Duration),
-			PingLatency:  make(map[string]time.Duration),
-			HTTPRequests: make(map[string]uint64),
-			StartTime:    time.Now(),
+			HTTPLatency:        make(map[string]time.Duration),
+			PingLatency:        make(map[string]time.Duration),
+			HTTPRequests:       make(map[string]uint64),
+			WebSocketEvictions: make(map[string]uint64),
+			DroppedEvents:      make(map[string]uint64),
+			StartTime:          time.Now(),
 		}
 
 		// Start background metric collectors
@@ -54,21 +61,21 @@ func InitMonitor() {
 func collectSystemMetrics() {
 	for {
 		if percent, err := cpu.Percent(time.Second, false); err == nil {
-			metrics.mu.Lock()
+			metricsMu.Lock()
 			metrics.CPUUsage = percent[0]
-			metrics.mu.Unlock()
+			metricsMu.Unlock()
 		}
 
 		if memStat, err := mem.VirtualMemory(); err == nil {
-			metrics.mu.Lock()
+			metricsMu.Lock()
 			metrics.MemoryUsage = memStat.UsedPercent
-			metrics.mu.Unlock()
+			metricsMu.Unlock()
 		}
 
 		if diskStat, err := disk.Usage("/"); err == nil {
-			metrics.mu.Lock()
+			metricsMu.Lock()
 			metrics.DiskUsage = diskStat.UsedPercent
-			metrics.mu.Unlock()
+			metricsMu.Unlock()
 		}
 
 		time.Sleep(5 * time.Second)
@@ -85,9 +92,9 @@ func measureExternalResources() {
 			start := time.Now()
 			if resp, err := http.Get(url); err == nil {
 				resp.Body.Close()
-				metrics.mu.Lock()
+				metricsMu.Lock()
 				metrics.HTTPLatency[url] = time.Since(start)
-				metrics.mu.Unlock()
+				metricsMu.Unlock()
 			}
 		}
 
@@ -98,9 +105,9 @@ func measureExternalResources() {
 				pinger.Count = 3
 				pinger.Timeout = 5 * time.Second
 				if err := pinger.Run(); err == nil {
-					metrics.mu.Lock()
+					metricsMu.Lock()
 					metrics.PingLatency[host] = pinger.Statistics().AvgRtt
-					metrics.mu.Unlock()
+					metricsMu.Unlock()
 				}
 			}
 		}
@@ -111,54 +118,101 @@ func measureExternalResources() {
 
 func printMetricsToConsole() {
 	for {
-		metrics.mu.RLock()
+		metricsMu.RLock()
 		fmt.Printf("\n=== Server Metrics ===\n")
 		fmt.Printf("Uptime: %s\n", time.Since(metrics.StartTime).Round(time.Second))
 		fmt.Printf("CPU Usage: %.2f%%\n", metrics.CPUUsage)
 		fmt.Printf("Memory Usage: %.2f%%\n", metrics.MemoryUsage)
 		fmt.Printf("Disk Usage: %.2f%%\n", metrics.DiskUsage)
 		fmt.Printf("WebSocket Connections: %d\n", metrics.WebSocketConnections)
+		fmt.Printf("WebSocket Evictions: %v\n", metrics.WebSocketEvictions)
+		fmt.Printf("Dropped Events: %v\n", metrics.DroppedEvents)
 		fmt.Printf("Worker Pool - Active: %d, Pending: %d, Processed: %d\n",
 			metrics.WorkerPool.Active,
 			metrics.WorkerPool.Pending,
 			metrics.WorkerPool.Processed)
-		metrics.mu.RUnlock()
+		metricsMu.RUnlock()
 		time.Sleep(10 * time.Second)
 	}
 }
 
 // Exported functions to update metrics
 func RecordWebSocketConnection(connected bool) {
-	metrics.mu.Lock()
-	defer metrics.mu.Unlock()
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
 	if connected {
 		metrics.WebSocketConnections++
+		wsConnections.Inc()
 	} else {
 		metrics.WebSocketConnections--
+		wsConnections.Dec()
 	}
 }
 
-func RecordHTTPRequest(path string, duration time.Duration) {
-	metrics.mu.Lock()
-	defer metrics.mu.Unlock()
-	metrics.HTTPRequests[path]++
+func RecordWebSocketEviction(reason string) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	if metrics.WebSocketEvictions == nil {
+		metrics.WebSocketEvictions = make(map[string]uint64)
+	}
+	metrics.WebSocketEvictions[reason]++
+	wsEvictions.WithLabelValues(reason).Inc()
+}
+
+// RecordDroppedEvents counts events that never reached a client, by reason.
+func RecordDroppedEvents(reason string, count int) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	if metrics.DroppedEvents == nil {
+		metrics.DroppedEvents = make(map[string]uint64)
+	}
+	metrics.DroppedEvents[reason] += uint64(count)
+	droppedEvents.WithLabelValues(reason).Add(float64(count))
+}
+
+// RecordHTTPRequest counts a request by route, HTTPMetricsMiddleware calls it
+// for every request.
+func RecordHTTPRequest(method string, route string, status int, duration time.Duration) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	if metrics.HTTPRequests == nil {
+		metrics.HTTPRequests = make(map[string]uint64)
+	}
+	metrics.HTTPRequests[route]++
+	recordHTTPMetrics(method, route, status, duration)
 }
 
+// GetMetrics returns a snapshot of the metrics.
 func GetMetrics() ServerMetrics {
-	metrics.mu.RLock()
-	defer metrics.mu.RUnlock()
-	return metrics
+	metricsMu.RLock()
+	defer metricsMu.RUnlock()
+
+	snapshot := metrics
+	snapshot.HTTPLatency = copyMap(metrics.HTTPLatency)
+	snapshot.PingLatency = copyMap(metrics.PingLatency)
+	snapshot.WebSocketEvictions = copyMap(metrics.WebSocketEvictions)
+	snapshot.DroppedEvents = copyMap(metrics.DroppedEvents)
+	snapshot.HTTPRequests = copyMap(metrics.HTTPRequests)
+	return snapshot
+}
+
+func copyMap[V any](m map[string]V) map[string]V {
+	copied := make(map[string]V, len(m))
+	for key, value := range m {
+		copied[key] = value
+	}
+	return copied
 }
 
 func CollectWorkerPoolMetrics(wp *WorkerPoolImpl) {
 	for {
 		active, pending, processed := wp.GetMetrics()
 
-		metrics.mu.Lock()
+		metricsMu.Lock()
 		metrics.WorkerPool.Active = active
 		metrics.WorkerPool.Pending = pending
 		metrics.WorkerPool.Processed = processed
-		metrics.mu.Unlock()
+		metricsMu.Unlock()
 
 		time.Sleep(1 * time.Second)
 	}
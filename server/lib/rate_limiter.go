@@ -0,0 +1,84 @@
+package lib
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimiter keeps a token bucket per key. A bucket holds up to burst tokens
+// and refills at perMinute tokens a minute, every allowed call takes one.
+type RateLimiter struct {
+	mu        sync.Mutex
+	rate      float64 // tokens per second
+	burst     float64
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewRateLimiter returns a limiter allowing perMinute calls a minute per key,
+// up to burst of them at once. A perMinute of 0 or less disables it.
+func NewRateLimiter(perMinute int, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   math.Max(float64(burst), 1),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a token from the bucket of key. When there is none left it
+// returns false and how long it takes until there is.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	if l.rate <= 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, updated: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.updated = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+func (l *RateLimiter) refill(b *bucket, now time.Time) float64 {
+	return math.Min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*l.rate)
+}
+
+// sweep forgets, once a minute, the buckets that are full again, as a new
+// bucket would start full too. It expects l.mu to be held.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if l.refill(b, now) >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// NewRateLimiterFromEnv reads the limits from <name>_PER_MINUTE and
+// <name>_BURST, falling back to the given defaults.
+func NewRateLimiterFromEnv(name string, perMinute int, burst int) *RateLimiter {
+	return NewRateLimiter(GetIntDotEnvDefault(name+"_PER_MINUTE", perMinute), GetIntDotEnvDefault(name+"_BURST", burst))
+}
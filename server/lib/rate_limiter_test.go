@@ -0,0 +1,46 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	t.Run("Burst then refill", func(t *testing.T) {
+		now := time.Now()
+		limiter := NewRateLimiter(60, 2)
+		limiter.now = func() time.Time { return now }
+
+		allowed, _ := limiter.Allow("alice")
+		assert.True(t, allowed)
+		allowed, _ = limiter.Allow("alice")
+		assert.True(t, allowed)
+		allowed, retryAfter := limiter.Allow("alice")
+		assert.False(t, allowed)
+		assert.Equal(t, time.Second, retryAfter)
+
+		now = now.Add(time.Second)
+		allowed, _ = limiter.Allow("alice")
+		assert.True(t, allowed)
+	})
+
+	t.Run("Keys have their own bucket", func(t *testing.T) {
+		limiter := NewRateLimiter(60, 1)
+
+		allowed, _ := limiter.Allow("alice")
+		assert.True(t, allowed)
+		allowed, _ = limiter.Allow("bob")
+		assert.True(t, allowed)
+	})
+
+	t.Run("Zero rate disables the limiter", func(t *testing.T) {
+		limiter := NewRateLimiter(0, 0)
+
+		for i := 0; i < 10; i++ {
+			allowed, _ := limiter.Allow("alice")
+			assert.True(t, allowed)
+		}
+	})
+}
@@ -4,8 +4,11 @@ import (
 	"github.com/google/uuid"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
+const drainPollInterval = 50 * time.Millisecond
+
 type Task[D any] struct {
 	ID   uuid.UUID
 	Data D
@@ -33,6 +36,7 @@ type WorkerPoolImpl struct {
 	active    int64
 	processed int64
 	pending   int64
+	completed int64
 
 	quitChan   chan struct{}
 	workerLock sync.Mutex
@@ -62,6 +66,7 @@ func (wp *WorkerPoolImpl) worker(id uuid.UUID, quit chan struct{}) {
 			atomic.AddInt64(&wp.processed, 1)
 			wp.workerFn(task)
 			atomic.AddInt64(&wp.processed, -1)
+			atomic.AddInt64(&wp.completed, 1)
 		case <-quit:
 			return
 		case <-wp.quitChan:
@@ -71,8 +76,9 @@ func (wp *WorkerPoolImpl) worker(id uuid.UUID, quit chan struct{}) {
 }
 
 func (wp *WorkerPoolImpl) EnqueueTask(task Task[map[string]any]) {
+	// Counted first, so a worker taking it right away can't leave pending negative
+	atomic.AddInt64(&wp.pending, 1)
 	wp.jobQueue <- task
-	atomic.AddInt64(&wp.pending, 1) // Task added to queue
 }
 
 func (wp *WorkerPoolImpl) ScaleUp(num int) []uuid.UUID {
@@ -95,10 +101,11 @@ func (wp *WorkerPoolImpl) ScaleDown(workerId uuid.UUID) {
 	wp.workerLock.Lock()
 	defer wp.workerLock.Unlock()
 
-	quit := wp.workers[workerId]
-
-	atomic.AddInt64(&wp.active, -1)
-	wp.wg.Add(-1)
+	// The worker updates the counters itself on its way out
+	quit, ok := wp.workers[workerId]
+	if !ok {
+		return
+	}
 	close(quit)
 	delete(wp.workers, workerId)
 }
@@ -109,6 +116,46 @@ func (wp *WorkerPoolImpl) Shutdown() {
 	close(wp.jobQueue)
 }
 
+// Drain waits up to timeout for the queued and running tasks to finish, then
+// stops the workers. It reports whether every task finished in time and
+// returns by the deadline even when a task hangs, leaving its worker behind.
+// Unlike Shutdown it leaves the queue open, so late tasks are dropped rather
+// than panicking.
+func (wp *WorkerPoolImpl) Drain(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	drained := false
+	for {
+		_, pending, running := wp.GetMetrics()
+		if drained = pending <= 0 && running <= 0; drained || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(drainPollInterval)
+	}
+
+	close(wp.quitChan)
+	stopped := make(chan struct{})
+	go func() {
+		wp.wg.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return drained
+	case <-time.After(time.Until(deadline)):
+		return false
+	}
+}
+
+// Completed returns how many tasks the workers finished since the start.
+func (wp *WorkerPoolImpl) Completed() int64 {
+	return atomic.LoadInt64(&wp.completed)
+}
+
+// QueueSize returns how many tasks can wait in the queue.
+func (wp *WorkerPoolImpl) QueueSize() int {
+	return cap(wp.jobQueue)
+}
+
 func (wp *WorkerPoolImpl) WorkerCount() int {
 	wp.workerLock.Lock()
 	defer wp.workerLock.Unlock()
@@ -0,0 +1,57 @@
+package lib
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorkerPool(t *testing.T) {
+	t.Run("ScaleDown stops a worker once", func(t *testing.T) {
+		wp := NewWorkerPool(WorkerPoolConfig{WorkerFn: func(Task[map[string]any]) {}, NumWorkers: 1})
+		ids := wp.ScaleUp(2)
+
+		wp.ScaleDown(ids[0])
+		wp.ScaleDown(ids[0])
+
+		assert.Eventually(t, func() bool {
+			active, _, _ := wp.GetMetrics()
+			return active == 1
+		}, time.Second, 10*time.Millisecond)
+		assert.Equal(t, 1, wp.WorkerCount())
+	})
+
+	t.Run("Drain runs the queued tasks before stopping", func(t *testing.T) {
+		var done int64
+		wp := NewWorkerPool(WorkerPoolConfig{NumWorkers: 10, WorkerFn: func(Task[map[string]any]) {
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt64(&done, 1)
+		}})
+		wp.ScaleUp(2)
+		for i := 0; i < 6; i++ {
+			wp.EnqueueTask(Task[map[string]any]{})
+		}
+
+		assert.True(t, wp.Drain(time.Second))
+		assert.Equal(t, int64(6), atomic.LoadInt64(&done))
+		active, _, _ := wp.GetMetrics()
+		assert.Zero(t, active)
+	})
+
+	t.Run("Drain gives up at the deadline", func(t *testing.T) {
+		release := make(chan struct{})
+		wp := NewWorkerPool(WorkerPoolConfig{NumWorkers: 1, WorkerFn: func(Task[map[string]any]) { <-release }})
+		wp.ScaleUp(1)
+		wp.EnqueueTask(Task[map[string]any]{})
+
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			close(release)
+		}()
+		start := time.Now()
+		assert.False(t, wp.Drain(20*time.Millisecond))
+		assert.Less(t, time.Since(start), 100*time.Millisecond)
+	})
+}
@@ -1,10 +1,12 @@
 package session
 
 import (
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 	"golang.org/x/crypto/bcrypt"
 	"main/lib"
 	"main/state"
@@ -26,6 +28,21 @@ type RefreshToken struct {
 
 var refreshTokens = make(map[string]*RefreshToken)
 
+var ErrTokenExpired = errors.New("token expired")
+
+// Reasons recorded by lib.RecordAuthFailure.
+const (
+	AuthMissingToken       = "missing_token"
+	AuthInvalidToken       = "invalid_token"
+	AuthTokenExpired       = "token_expired"
+	AuthUnknownUser        = "unknown_user"
+	AuthInvalidCredentials = "invalid_credentials"
+)
+
+// AccessTokenProtocol is the Sec-WebSocket-Protocol entry announcing that the
+// next entry is an access token.
+const AccessTokenProtocol = "access_token"
+
 type Claims struct {
 	UserID    string    `json:"user_id"`
 	Role      string    `json:"role"`
@@ -39,6 +56,9 @@ func ParseToken(tokenString string) (*Claims, error) {
 		return lib.GetDotEnv("JWT_SECRET"), nil
 	})
 
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return nil, ErrTokenExpired
+	}
 	if err != nil || !token.Valid {
 		return nil, fmt.Errorf("invalid token")
 	}
@@ -46,6 +66,26 @@ func ParseToken(tokenString string) (*Claims, error) {
 	return token.Claims.(*Claims), nil
 }
 
+// RequestAccessToken looks up the access token of a request. Browsers can't set
+// headers on a WebSocket handshake, so besides the access_token header the token
+// is accepted as a ("access_token", "<token>") Sec-WebSocket-Protocol pair or as
+// the access_token query param. When the token came from the subprotocol list,
+// protocol holds the entry that has to be echoed back to the client.
+func RequestAccessToken(r *http.Request) (token string, protocol string) {
+	if token = r.Header.Get("access_token"); token != "" {
+		return token, ""
+	}
+
+	protocols := websocket.Subprotocols(r)
+	for i := 0; i < len(protocols)-1; i++ {
+		if protocols[i] == AccessTokenProtocol {
+			return protocols[i+1], AccessTokenProtocol
+		}
+	}
+
+	return r.URL.Query().Get("access_token"), ""
+}
+
 func GenerateToken(userID string, expires time.Time) (string, error) {
 	claims := &Claims{
 		UserID: userID,
@@ -63,6 +103,7 @@ func AuthMiddleware(strict bool) gin.HandlerFunc {
 		accessToken := c.GetHeader("access_token")
 		refreshToken := c.GetHeader("refresh_token")
 		if accessToken == "" {
+			lib.RecordAuthFailure(AuthMissingToken)
 			c.AbortWithStatus(http.StatusUnauthorized)
 			return
 		}
@@ -71,6 +112,7 @@ func AuthMiddleware(strict bool) gin.HandlerFunc {
 		if err != nil || (strict && time.Now().After(claims.ExpiresAt)) {
 			refreshClaims, err := ParseToken(refreshToken)
 			if err != nil || (time.Now().After(refreshClaims.ExpiresAt)) {
+				lib.RecordAuthFailure(AuthInvalidToken)
 				c.AbortWithStatus(http.StatusUnauthorized)
 			}
 
@@ -112,6 +154,7 @@ func AuthorizeHandler(c *gin.Context) {
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
 
 	if user.Verified != true || user.Password == "" || err != nil {
+		lib.RecordAuthFailure(AuthInvalidCredentials)
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	}
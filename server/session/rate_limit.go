@@ -0,0 +1,31 @@
+package session
+
+import (
+	"main/lib"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitMiddleware limits requests per user, or per client IP on routes
+// AuthMiddleware doesn't guard. Limited requests get 429 with Retry-After.
+func RateLimitMiddleware(limiter *lib.RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := "ip:" + c.ClientIP()
+		if userID := c.GetString("userID"); userID != "" {
+			key = "user:" + userID
+		}
+
+		if allowed, retryAfter := limiter.Allow(key); !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"status":  "Error",
+				"message": "Too many requests",
+			})
+			return
+		}
+		c.Next()
+	}
+}
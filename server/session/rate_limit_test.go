@@ -0,0 +1,47 @@
+package session
+
+import (
+	"main/lib"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	newRouter := func(trustedProxies []string) *gin.Engine {
+		r := gin.New()
+		assert.NoError(t, r.SetTrustedProxies(trustedProxies))
+		r.Use(RateLimitMiddleware(lib.NewRateLimiter(60, 1)))
+		r.GET("/status", func(c *gin.Context) { c.Status(http.StatusOK) })
+		return r
+	}
+	get := func(r *gin.Engine, forwardedFor string) int {
+		req := httptest.NewRequest(http.MethodGet, "/status", nil)
+		req.RemoteAddr = "1.2.3.4:5678"
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	t.Run("Spoofed X-Forwarded-For shares the bucket of the connection", func(t *testing.T) {
+		r := newRouter(nil)
+
+		assert.Equal(t, http.StatusOK, get(r, ""))
+		assert.Equal(t, http.StatusTooManyRequests, get(r, "9.9.9.9"))
+	})
+
+	t.Run("X-Forwarded-For from a trusted proxy gets its own bucket", func(t *testing.T) {
+		r := newRouter([]string{"1.2.3.4"})
+
+		assert.Equal(t, http.StatusOK, get(r, "9.9.9.9"))
+		assert.Equal(t, http.StatusOK, get(r, "8.8.8.8"))
+		assert.Equal(t, http.StatusTooManyRequests, get(r, "8.8.8.8"))
+	})
+}
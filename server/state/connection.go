@@ -1,7 +1,9 @@
 package state
 
 import (
+	"context"
 	"fmt"
+	"go.uber.org/zap"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"main/lib"
@@ -9,16 +11,23 @@ import (
 
 var database *gorm.DB
 
-func createConnection() {
+// DSN returns the connection string of the database set by the PSQL_* env.
+func DSN() string {
 	dbHost := lib.GetDotEnv("PSQL_HOST")
 	dbUser := lib.GetDotEnv("PSQL_USER")
 	dbPassword := lib.GetDotEnv("PSQL_PASSWORD")
 	dbName := lib.GetDotEnv("PSQL_DB")
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=5432 TimeZone=Europe/Warsaw", dbHost, dbUser, dbPassword, dbName)
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	return fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=5432 TimeZone=Europe/Warsaw", dbHost, dbUser, dbPassword, dbName)
+}
+
+func createConnection() {
+	db, err := gorm.Open(postgres.Open(DSN()), &gorm.Config{})
 	if err != nil {
 		panic("failed to connect database")
 	}
+	if err := instrument(db); err != nil {
+		lib.GetLogger().Warn("failed to instrument database queries", zap.Error(err))
+	}
 	database = db
 }
 
@@ -28,3 +37,24 @@ func GetConnection() *gorm.DB {
 	}
 	return database
 }
+
+// Ping checks the database answers.
+func Ping(ctx context.Context) error {
+	db, err := GetConnection().DB()
+	if err != nil {
+		return err
+	}
+	return db.PingContext(ctx)
+}
+
+// Close closes the connection pool, if it was opened.
+func Close() error {
+	if database == nil {
+		return nil
+	}
+	db, err := database.DB()
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
@@ -1,9 +1,16 @@
 package entity
 
+const (
+	ChatRoomKindGroup  = "group"
+	ChatRoomKindDirect = "direct"
+)
+
 type ChatRoom struct {
-	ID      string `gorm:"type:varchar(255);primary_key"`
-	Name    string `gorm:"type:varchar(255);not null"`
-	Members string `gorm:"type:jsonb;not null"`
+	ID   string `gorm:"type:varchar(255);primary_key"`
+	Name string `gorm:"type:varchar(255);not null"`
+	Kind string `gorm:"type:varchar(20);not null;default:group"`
+	// LastSeq is the sequence number of the latest message of the room.
+	LastSeq int64 `gorm:"not null;default:0"`
 }
 
 func (ChatRoom) TableName() string {
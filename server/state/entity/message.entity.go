@@ -1,18 +1,51 @@
 package entity
 
-import "time"
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+)
 
 type Message struct {
-	ID         string    `gorm:"type:uuid;primary_key"`
-	ChatRoomID string    `gorm:"type:varchar(255);not null"`
-	AuthorID   string    `gorm:"type:uuid;not null"`
-	Text       string    `gorm:"type:text;not null"`
-	SeenBy     string    `gorm:"type:jsonb;not null"`
-	ReceivedBy string    `gorm:"type:jsonb;not null"`
-	SentAt     time.Time `gorm:"not null;default:current_timestamp"`
-	DeletedAt  time.Time
+	ID         string `gorm:"type:uuid;primary_key"`
+	ChatRoomID string `gorm:"type:varchar(255);not null"`
+	Seq        int64  `gorm:"not null"`
+	AuthorID   string `gorm:"type:uuid;not null"`
+	// ClientMsgID is the idempotency key the author's client sent the message with
+	ClientMsgID string    `gorm:"type:varchar(64);not null;default:''"`
+	Text        string    `gorm:"type:text;not null"`
+	SeenBy      Receipts  `gorm:"type:jsonb;not null"`
+	ReceivedBy  Receipts  `gorm:"type:jsonb;not null"`
+	SentAt      time.Time `gorm:"not null;default:current_timestamp"`
+	EditedAt    *time.Time
+	DeletedAt   *time.Time
 }
 
 func (Message) TableName() string {
 	return "messages"
 }
+
+// Receipts maps user IDs to the time they received or saw a message.
+type Receipts map[string]time.Time
+
+func (r Receipts) Value() (driver.Value, error) {
+	if r == nil {
+		return "{}", nil
+	}
+	value, err := json.Marshal(r)
+	return string(value), err
+}
+
+func (r *Receipts) Scan(src any) error {
+	switch value := src.(type) {
+	case []byte:
+		return json.Unmarshal(value, r)
+	case string:
+		return json.Unmarshal([]byte(value), r)
+	case nil:
+		*r = Receipts{}
+		return nil
+	}
+	return errors.New("unsupported receipts value")
+}
@@ -0,0 +1,17 @@
+package entity
+
+import "time"
+
+// MessageEdit keeps the text a message had before it was edited or deleted.
+type MessageEdit struct {
+	ID           string    `gorm:"type:uuid;primary_key"`
+	MessageID    string    `gorm:"type:uuid;not null"`
+	EditorID     string    `gorm:"type:uuid"`
+	PreviousText string    `gorm:"type:text;not null"`
+	Deleted      bool      `gorm:"type:bool;not null"`
+	EditedAt     time.Time `gorm:"not null;default:current_timestamp"`
+}
+
+func (MessageEdit) TableName() string {
+	return "message_edits"
+}
@@ -0,0 +1,22 @@
+package entity
+
+import "time"
+
+const (
+	RoomRoleOwner  = "owner"
+	RoomRoleAdmin  = "admin"
+	RoomRoleMember = "member"
+)
+
+type RoomMember struct {
+	RoomID            string    `gorm:"type:varchar(255);primary_key"`
+	UserID            string    `gorm:"type:uuid;primary_key"`
+	Role              string    `gorm:"type:varchar(50);not null"`
+	JoinedAt          time.Time `gorm:"not null;default:current_timestamp"`
+	MutedUntil        *time.Time
+	LastReadMessageID *string `gorm:"type:uuid"`
+}
+
+func (RoomMember) TableName() string {
+	return "room_members"
+}